@@ -3,8 +3,13 @@ module github.com/nfisher/majortom
 go 1.14
 
 require (
-	github.com/evanphx/json-patch v4.2.0+incompatible
-	github.com/google/go-cmp v0.3.0
-	k8s.io/api v0.18.4
-	k8s.io/apimachinery v0.18.4
+	github.com/evanphx/json-patch v4.9.0+incompatible
+	github.com/google/go-cmp v0.4.0
+	github.com/prometheus/client_golang v1.7.1
+	github.com/prometheus/client_model v0.2.0
+	google.golang.org/grpc v1.27.1
+	k8s.io/api v0.19.16
+	k8s.io/apimachinery v0.19.16
+	k8s.io/client-go v0.19.16
+	sigs.k8s.io/yaml v1.2.0
 )
@@ -1,279 +1,37 @@
 package main
 
 import (
-	"encoding/json"
-	"fmt"
-	"io"
-	"log"
-	"net/http"
+	"flag"
 	"os"
 
-	v1 "k8s.io/api/admission/v1"
-	corev1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"github.com/nfisher/majortom/pkg/majortom"
 )
 
-const (
-	DefaultAddr     = ":8443"
-	DefaultCertPath = "/run/secrets/tls/tls.crt"
-	DefaultKeyPath  = "/run/secrets/tls/tls.key"
-	ApplicationJson = `application/json`
-)
-
-var (
-	// Revision is the git revision of the binary
-	Revision = "dev"
-)
-
-const LogFlags = log.LstdFlags | log.LUTC | log.Lshortfile | log.Lmsgprefix
-
-func Exec(addr, certPath, keyPath string) {
-	prefix := fmt.Sprintf("rev=%s ", Revision)
-	log.SetFlags(LogFlags)
-	log.SetPrefix(prefix)
-	lg := log.New(os.Stderr, prefix, LogFlags)
-	mux := http.NewServeMux()
-	mux.HandleFunc("/labels/owner", bind(podPatch, VarPatch("NODEIP", "status.hostIP")))
-	server := &http.Server{
-		Addr: addr,
-		Handler: &logger{
-			Handler: mux,
-			Logger:  lg,
-		},
-	}
-	lg.Printf("status=binding addr=%s\n", server.Addr)
-	lg.Fatalln(server.ListenAndServeTLS(certPath, keyPath))
-}
-
 func main() {
-	Exec(DefaultAddr, DefaultCertPath, DefaultKeyPath)
-}
-
-var podResource = metav1.GroupVersionResource{Version: "v1", Resource: "pods"}
-
-func closer(c io.Closer) {
-	err := c.Close()
-	if err != nil {
-		log.Printf("error closing body err=%v\n", err)
-	}
-}
-
-var ErrPodHasOwnerLabel = fmt.Errorf("pod has owner")
-
-func AddOwner(pod *corev1.Pod) ([]operation, error) {
-	_, ok := pod.ObjectMeta.Labels["owner"]
-	if ok {
-		return nil, ErrPodHasOwnerLabel
-	}
-	op := addOp("/metadata/labels/owner", "nathan.fisher")
-	return []operation{op}, nil
-}
-
-func varReplace(cid, eid int, name, value string) operation {
-	path := fmt.Sprintf("/spec/containers/%d/env/%d", cid, eid)
-	pathValue := map[string]interface{}{
-		"name": name,
-		"valueFrom": map[string]interface{}{
-			"fieldRef": map[string]interface{}{
-				"fieldPath": value,
-			},
-		},
-	}
-	return replaceOp(path, pathValue)
-}
-
-func varAdd(cid, eid int, name, value string) operation {
-	if eid == 0 {
-		path := fmt.Sprintf("/spec/containers/%d/env", cid)
-		pathValue := []map[string]interface{}{
-			{
-				"name": name,
-				"valueFrom": map[string]interface{}{
-					"fieldRef": map[string]interface{}{
-						"fieldPath": value,
-					},
-				},
-			},
-		}
-		return addOp(path, pathValue)
-	}
-	path := fmt.Sprintf("/spec/containers/%d/env/%d", cid, eid)
-	pathValue := map[string]interface{}{
-		"name": name,
-		"valueFrom": map[string]interface{}{
-			"fieldRef": map[string]interface{}{
-				"fieldPath": value,
-			},
-		},
-	}
-	return addOp(path, pathValue)
-}
-
-func VarPatch(name, value string) PodPatchable {
-	return func(pod *corev1.Pod) ([]operation, error) {
-		var ops []operation
-		for i := range pod.Spec.Containers {
-			container := pod.Spec.Containers[i]
-			var op operation
-			var wasFound = false
-			for j := range container.Env {
-				env := container.Env[j]
-				if env.Name == name {
-					wasFound = true
-					op = varReplace(i, j, name, value)
-					break
-				}
-			}
-			if !wasFound {
-				op = varAdd(i, len(container.Env), name, value)
-			}
-			ops = append(ops, op)
-		}
-		return ops, nil
-	}
-}
-
-type PodPatchable func(*corev1.Pod) ([]operation, error)
-
-func bind(handler func(http.ResponseWriter, *http.Request, PodPatchable), patchable PodPatchable) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		handler(w, r, patchable)
-	}
-}
-
-func podPatch(w http.ResponseWriter, r *http.Request, apply PodPatchable) {
-	contentType := r.Header.Get("Content-Type")
-	if r.Method != http.MethodPost {
-		log.Printf("status=failed path=%s err='invalid request method %s'", r.URL.Path, r.Method)
-		http.Error(w, "only POST permitted", http.StatusMethodNotAllowed)
-		return
-	}
-	defer closer(r.Body)
-
-	if contentType != ApplicationJson {
-		log.Printf("status=failed path=%s err='invalid content-type %s'", r.URL.Path, contentType)
-		http.Error(w, "invalid content-type", http.StatusBadRequest)
-		return
-	}
-
-	var review v1.AdmissionReview
-	err := json.NewDecoder(r.Body).Decode(&review)
-	if err != nil {
-		log.Printf("status=failed path=%s err='admission review unmarshal: %v'", r.URL.Path, err)
-		http.Error(w, "error reading response body", http.StatusBadRequest)
-		return
-	}
-
-	if review.Request == nil {
-		log.Printf("status=failed path=%s err='request was nil'", r.URL.Path)
-		http.Error(w, "nil admission request", http.StatusBadRequest)
-		return
-	}
-
-	if isSystem(review.Request.Namespace) {
-		log.Printf("status=ignored path=%s err='system namespace %s'", r.URL.Path, review.Request.Namespace)
-		http.Error(w, "will not modify resource in kube-* namespace", http.StatusForbidden)
-		return
-	}
-
-	if review.Request.Resource != podResource {
-		log.Printf("status=failed path=%s err='unexpected resource got %#v, want %#v'", r.URL.Path, review.Request.Resource, podResource)
-		http.Error(w, "resource not a v1.Pod", http.StatusBadRequest)
-		return
-	}
-
-	var pod corev1.Pod
-	err = json.Unmarshal(review.Request.Object.Raw, &pod)
-	if err != nil {
-		log.Printf("status=failed path=%s err='pod unmarshal: %v'", r.URL.Path, err)
-		http.Error(w, "unable to unmarshal kubernetes v1.Pod", http.StatusBadRequest)
-		return
-	}
-
-	ops, err := apply(&pod)
-	if err != nil {
-		log.Printf("status=failed path=%s err='apply: %v'", r.URL.Path, err)
-		http.Error(w, err.Error(), http.StatusForbidden)
-		return
-	}
-
-	patch, err := json.Marshal(ops)
-	if err != nil {
-		log.Printf("status=failed path=%s err='ops marshal: %v'", r.URL.Path, err)
-		http.Error(w, "unable to marshal operation json", http.StatusInternalServerError)
-		return
-	}
-
-	pt := v1.PatchTypeJSONPatch
-	resp := v1.AdmissionReview{
-		TypeMeta: metav1.TypeMeta{Kind: "AdmissionReview", APIVersion: "admission.k8s.io/v1"},
-		Response: &v1.AdmissionResponse{
-			UID:       review.Request.UID,
-			Allowed:   true,
-			PatchType: &pt,
-			Patch:     patch,
-		},
-	}
-
-	w.Header().Set("Content-Type", ApplicationJson)
-	enc := json.NewEncoder(w)
-	err = enc.Encode(&resp)
-	if err != nil {
-		log.Printf("status=failed path=%s err='admission review marshal: %v'", r.URL.Path, err)
-		http.Error(w, "unable to encode response json", http.StatusInternalServerError)
-		return
-	}
-}
-
-type operation struct {
-	Op    string      `json:"op"`
-	Path  string      `json:"path"`
-	Value interface{} `json:"value,omitempty"`
-}
-
-func addOp(path string, value interface{}) operation {
-	return operation{
-		Op:    "add",
-		Path:  path,
-		Value: value,
-	}
-}
-
-func replaceOp(path string, value interface{}) operation {
-	return operation{
-		Op:    "replace",
-		Path:  path,
-		Value: value,
-	}
-}
-
-type responseCode struct {
-	http.ResponseWriter
-	code int
-}
-
-func (w *responseCode) WriteHeader(statusCode int) {
-	w.code = statusCode
-	w.ResponseWriter.WriteHeader(statusCode)
-}
-
-type logger struct {
-	Handler http.Handler
-	Logger  *log.Logger
-}
-
-func (l *logger) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	wc := &responseCode{w, http.StatusOK}
-	l.Handler.ServeHTTP(wc, r)
-	l.Logger.Printf("status=%d method=%s path=%s\n", wc.code, r.Method, r.URL.Path)
-}
-
-func isSystem(namespace string) bool {
-	if namespace == metav1.NamespacePublic {
-		return true
-	}
-	if namespace == metav1.NamespaceSystem {
-		return true
-	}
-	return false
+	flag.StringVar(&majortom.ConfigPath, "config", majortom.ConfigPath, "path to the YAML route config or a directory of them, the default route is served when empty")
+	flag.DurationVar(&majortom.ReloadInterval, "reload-interval", majortom.ReloadInterval, "how often the config is reloaded, 0 disables reloading")
+	flag.IntVar(&majortom.MaxReloadFailures, "max-reload-failures", majortom.MaxReloadFailures, "consecutive config reload failures before /livez fails, 0 disables")
+	flag.Var(&majortom.LogLevel, "log-level", "log verbosity one of error, warn, info, debug")
+	flag.Var(&majortom.DefaultFailPolicy, "fail-policy", "response when a patch can't be produced one of webhook, open, closed")
+	flag.Var(&majortom.EnvAppendStyle, "env-append-style", "how env patches append to an existing env one of index, dash")
+	flag.IntVar(&majortom.MaxOps, "max-ops", majortom.MaxOps, "maximum number of patch operations for a single pod")
+	flag.IntVar(&majortom.MaxPatchBytes, "max-patch-bytes", majortom.MaxPatchBytes, "maximum size in bytes of the patch for a single pod, 0 disables")
+	flag.BoolVar(&majortom.VerifyPatches, "verify-patches", majortom.VerifyPatches, "apply each patch in-memory before responding, counting failures in majortom_invalid_patch_total")
+	flag.Var(majortom.NamespaceAllowlist, "namespace-allowlist", "comma separated namespaces to mutate, all others and majortom's own are allowed unmodified")
+	flag.Var(majortom.FieldPathAllowlist, "field-path-allowlist", "comma separated fieldPaths vars may reference, metadata.labels or metadata.annotations allow any key, all valid fieldPaths when empty")
+	flag.Var(majortom.ExcludeTolerations, "exclude-tolerations", "comma separated toleration keys, pods tolerating any are allowed unmodified")
+	flag.BoolVar(&majortom.JSONErrors, "json-errors", majortom.JSONErrors, "write non-review error responses as JSON objects with error and code fields")
+	flag.StringVar(&majortom.GRPCAddr, "grpc-addr", majortom.GRPCAddr, "address to serve the gRPC admission service on, disabled when empty")
+	flag.StringVar(&majortom.GRPCClientCAPath, "grpc-client-ca", majortom.GRPCClientCAPath, "PEM bundle of CAs gRPC clients must present a certificate from, any client is accepted when empty")
+	flag.Var(&majortom.TrustedProxies, "trusted-proxies", "comma separated CIDRs of proxies whose X-Forwarded-For header identifies the client, ignored when empty")
+	flag.DurationVar(&majortom.ShutdownDelay, "shutdown-delay", majortom.ShutdownDelay, "how long admission requests are refused with 503 on SIGTERM before the server shuts down")
+	flag.DurationVar(&majortom.ShutdownTimeout, "shutdown-timeout", majortom.ShutdownTimeout, "how long in-flight requests have to complete once the server shuts down")
+	flag.BoolVar(&majortom.DebugEndpoints, "debug-endpoints", majortom.DebugEndpoints, "serve the /preview, /selfcheck and /routes debug endpoints")
+	flag.BoolVar(&majortom.NamespaceMetrics, "namespace-metrics", majortom.NamespaceMetrics, "count admission decisions by namespace in majortom_namespace_admission_total")
+	flag.Var(majortom.NamespaceMetricsAllowlist, "namespace-metrics-allowlist", "comma separated namespaces counted individually by -namespace-metrics, all others are counted as other")
+	flag.BoolVar(&majortom.AuditDecisions, "audit-decisions", majortom.AuditDecisions, "log a decision record for every reviewed pod including those that aren't patched")
+	flag.BoolVar(&majortom.IgnoreOtherResources, "ignore-other-resources", majortom.IgnoreOtherResources, "allow resources other than pods without modification instead of rejecting them")
+	flag.Parse()
+	majortom.OwnNamespace = os.Getenv("POD_NAMESPACE")
+	majortom.Exec(majortom.DefaultAddr, majortom.DefaultCertPath, majortom.DefaultKeyPath)
 }
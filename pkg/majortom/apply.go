@@ -0,0 +1,165 @@
+package majortom
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ApplyOps applies ops to a copy of pod in-memory returning the patched pod.
+func ApplyOps(pod *corev1.Pod, ops []operation) (*corev1.Pod, error) {
+	podBytes, err := json.Marshal(pod)
+	if err != nil {
+		return nil, err
+	}
+	if len(ops) == 0 {
+		var unpatched corev1.Pod
+		err = json.Unmarshal(podBytes, &unpatched)
+		return &unpatched, err
+	}
+	patchBytes, err := json.Marshal(ops)
+	if err != nil {
+		return nil, err
+	}
+	patch, err := jsonpatch.DecodePatch(patchBytes)
+	if err != nil {
+		return nil, err
+	}
+	patched, err := patch.Apply(podBytes)
+	if err != nil {
+		return nil, err
+	}
+	var podWithPatch corev1.Pod
+	err = json.Unmarshal(patched, &podWithPatch)
+	if err != nil {
+		return nil, err
+	}
+	return &podWithPatch, nil
+}
+
+// verifyOps applies ops to pod in-memory one at a time so a patch the apiserver would reject is caught along with the
+// op responsible. The failing op is returned as JSON, empty when every op applied but the result isn't a valid pod.
+func verifyOps(pod *corev1.Pod, ops []operation) (string, error) {
+	doc, err := json.Marshal(pod)
+	if err != nil {
+		return "", err
+	}
+	for _, op := range ops {
+		b, err := marshal([]operation{op})
+		if err != nil {
+			return "", err
+		}
+		patch, err := jsonpatch.DecodePatch(b)
+		if err == nil {
+			doc, err = patch.Apply(doc)
+		}
+		if err != nil {
+			rendered, _ := marshal(op)
+			return string(rendered), err
+		}
+	}
+	var patched corev1.Pod
+	return "", json.Unmarshal(doc, &patched)
+}
+
+// preview applies the patchable registered for the route query parameter to a raw pod returning the patched pod.
+// It isn't an admission endpoint, it lets developers see what majortom would do with curl.
+func (rt *Router) preview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		LevelWarn.Printf("status=failed path=%s err='invalid request method %s'", r.URL.Path, r.Method)
+		httpError(w, "only POST permitted", http.StatusMethodNotAllowed)
+		return
+	}
+	defer closer(r.Body)
+
+	route := r.URL.Query().Get("route")
+	apply, ok := rt.patchables[route]
+	if !ok {
+		LevelWarn.Printf("status=failed path=%s err='unknown route %s'", r.URL.Path, route)
+		httpError(w, "unknown route", http.StatusNotFound)
+		return
+	}
+
+	var pod corev1.Pod
+	err := json.NewDecoder(r.Body).Decode(&pod)
+	if err != nil {
+		LevelWarn.Printf("status=failed path=%s err='pod unmarshal: %s'", r.URL.Path, decodeError(err))
+		httpError(w, "unable to unmarshal kubernetes v1.Pod", http.StatusBadRequest)
+		return
+	}
+
+	ops, err := apply(&pod)
+	if err != nil {
+		LevelInfo.Printf("status=failed path=%s err='apply: %v'", r.URL.Path, err)
+		httpError(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if len(ops) > 0 {
+		ops = append(ops, revisionOps(&pod, ops)...)
+	}
+
+	patched, err := ApplyOps(&pod, ops)
+	if err != nil {
+		LevelError.Printf("status=failed path=%s err='apply ops: %v'", r.URL.Path, err)
+		httpError(w, "unable to apply patch", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", ApplicationJson)
+	err = json.NewEncoder(w).Encode(patched)
+	if err != nil {
+		LevelError.Printf("status=failed path=%s err='pod marshal: %v'", r.URL.Path, err)
+	}
+}
+
+// selfCheckPod is the synthetic pod run through each route by SelfCheck.
+func selfCheckPod() *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "majortom-selfcheck", Namespace: "default"},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{
+			{Name: "app", Image: "nginx:1.19.2", Ports: []corev1.ContainerPort{{ContainerPort: 8080}}},
+		}},
+	}
+}
+
+// SelfCheck runs a synthetic pod through every registered patchable and applies the resulting ops in-memory. It
+// returns the first route whose patchable fails or produces a patch that doesn't apply, catching broken config before
+// the apiserver does.
+func (rt *Router) SelfCheck() error {
+	for _, route := range rt.routes {
+		apply, ok := rt.patchables[route]
+		if !ok {
+			continue
+		}
+		pod := selfCheckPod()
+		ops, err := apply(pod)
+		if err != nil {
+			return fmt.Errorf("route %s: %w", route, err)
+		}
+		if len(ops) > 0 {
+			ops = append(ops, revisionOps(pod, ops)...)
+		}
+		_, err = ApplyOps(pod, ops)
+		if err != nil {
+			return fmt.Errorf("route %s: apply ops: %w", route, err)
+		}
+	}
+	return nil
+}
+
+// SelfCheckHandler returns a handler reporting SelfCheck failures as unavailable.
+func (rt *Router) SelfCheckHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := rt.SelfCheck()
+		if err != nil {
+			LevelWarn.Printf("status=failed path=%s err='selfcheck: %v'", r.URL.Path, err)
+			http.Error(w, "selfcheck failed: "+err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("ok\n"))
+	})
+}
@@ -0,0 +1,95 @@
+package majortom
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func Test_ApplyOps_invalid_patch(t *testing.T) {
+	_, err := ApplyOps(&corev1.Pod{}, []operation{replaceOp("/spec/containers/3/env", nil)})
+	if err == nil {
+		t.Error("err=nil, want error")
+	}
+}
+
+func Test_ApplyOps_without_ops(t *testing.T) {
+	pod := &corev1.Pod{Spec: corev1.PodSpec{NodeName: "node-1"}}
+	patched, err := ApplyOps(pod, nil)
+	if err != nil {
+		t.Errorf("err=%v, want nil", err)
+	}
+	if patched.Spec.NodeName != "node-1" {
+		t.Errorf("patched.Spec.NodeName=%v, want node-1", patched.Spec.NodeName)
+	}
+}
+
+func previewRequest(route string, pod *corev1.Pod) *http.Request {
+	b, _ := json.Marshal(pod)
+	r, _ := http.NewRequest(http.MethodPost, "/preview?route="+route, bytes.NewBuffer(b))
+	r.Header.Set("Content-Type", ApplicationJson)
+	return r
+}
+
+func Test_preview_returns_patched_pod(t *testing.T) {
+	rt := NewRouter()
+	rt.Handle("/labels/owner", VarPatch("NODEIP", "status.hostIP"))
+	w := httptest.NewRecorder()
+	rt.Preview().ServeHTTP(w, previewRequest("/labels/owner", &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Image: "nginx:latest"}}}}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("w.Code=%v, want StatusOK", w.Code)
+	}
+
+	var patched corev1.Pod
+	err := json.NewDecoder(w.Body).Decode(&patched)
+	if err != nil {
+		t.Fatalf("Decode err=%v, want nil", err)
+	}
+	env := patched.Spec.Containers[0].Env
+	if len(env) != 1 || env[0].Name != "NODEIP" {
+		t.Errorf("env=%#v, want NODEIP", env)
+	}
+	if patched.Annotations[RevisionAnnotation] != Revision {
+		t.Errorf("annotations[%s]=%v, want %v", RevisionAnnotation, patched.Annotations[RevisionAnnotation], Revision)
+	}
+}
+
+func Test_preview_unknown_route(t *testing.T) {
+	rt := NewRouter()
+	w := httptest.NewRecorder()
+	rt.Preview().ServeHTTP(w, previewRequest("/labels/owner", &corev1.Pod{}))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("w.Code=%v, want StatusNotFound", w.Code)
+	}
+}
+
+func Test_Selfcheck(t *testing.T) {
+	broken := func(*corev1.Pod) ([]operation, error) {
+		return []operation{replaceOp("/spec/containers/3/image", "nginx:latest")}, nil
+	}
+	cases := map[string]struct {
+		p    PodPatchable
+		code int
+	}{
+		"valid config":      {Chain(AddOwner, VarPatch("NODEIP", "status.hostIP")), http.StatusOK},
+		"invalid patch":     {broken, http.StatusServiceUnavailable},
+		"failing patchable": {VarPatch("NODEIP", "spec.containers"), http.StatusServiceUnavailable},
+	}
+
+	for n, tc := range cases {
+		tc := tc
+		t.Run(n, func(t *testing.T) {
+			rt := NewRouter()
+			rt.Handle("/labels/owner", tc.p)
+			w := httptest.NewRecorder()
+			rt.SelfCheckHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/selfcheck", nil))
+			if w.Code != tc.code {
+				t.Errorf("w.Code=%v, want %v body=%q", w.Code, tc.code, w.Body.String())
+			}
+		})
+	}
+}
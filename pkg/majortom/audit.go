@@ -0,0 +1,36 @@
+package majortom
+
+import (
+	"fmt"
+	"log"
+
+	v1 "k8s.io/api/admission/v1"
+)
+
+// AuditDecisions enables a record of every admission decision, including the pods majortom declined to patch, so
+// missing injections can be traced to their cause.
+var AuditDecisions = false
+
+// Audit decisions recorded for reviewed requests.
+const (
+	DecisionPatched         = "patched"
+	DecisionUnchanged       = "unchanged"
+	DecisionFailed          = "failed"
+	DecisionSystemNamespace = "ignored:system-ns"
+	DecisionAllowlist       = "ignored:allowlist"
+	DecisionOptOut          = "ignored:opt-out"
+	DecisionSubresource     = "ignored:subresource"
+	DecisionOtherResource   = "ignored:other-resource"
+	DecisionToleration      = "ignored:toleration"
+	DecisionProcessed       = "ignored:processed"
+	DecisionWrongResource   = "rejected:wrong-resource"
+	DecisionInvalidPod      = "rejected:invalid-pod"
+)
+
+// audit records decision for req when AuditDecisions is enabled. Records are written regardless of LogLevel.
+func audit(path string, req *v1.AdmissionRequest, decision string) {
+	if !AuditDecisions {
+		return
+	}
+	_ = log.Output(2, fmt.Sprintf("status=audit path=%s uid=%s namespace=%s operation=%s decision=%s", path, req.UID, req.Namespace, req.Operation, decision))
+}
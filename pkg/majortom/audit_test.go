@@ -0,0 +1,58 @@
+package majortom
+
+import (
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func Test_audit_decisions(t *testing.T) {
+	AuditDecisions = true
+	defer func() { AuditDecisions = false }()
+	withNamespaces(t, namespace("excluded", map[string]string{ExcludeLabel: "true"}))
+	deployments := metav1.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+	noop := func(*corev1.Pod) ([]operation, error) { return nil, nil }
+	cases := map[string]struct {
+		req                  v1.AdmissionRequest
+		ignoreOtherResources bool
+		apply                PodPatchable
+		decision             string
+	}{
+		"patched":        {v1.AdmissionRequest{Namespace: "default", Resource: resourcePods, Object: tidePod()}, true, AddOwner, DecisionPatched},
+		"unchanged":      {v1.AdmissionRequest{Namespace: "default", Resource: resourcePods, Object: tidePod()}, true, noop, DecisionUnchanged},
+		"failed":         {v1.AdmissionRequest{Namespace: "default", Resource: resourcePods, Object: podWithOwnerLabel()}, true, AddOwner, DecisionFailed},
+		"system ns":      {v1.AdmissionRequest{Namespace: "kube-system", Resource: resourcePods, Object: tidePod()}, true, AddOwner, DecisionSystemNamespace},
+		"opt-out":        {v1.AdmissionRequest{Namespace: "excluded", Resource: resourcePods, Object: tidePod()}, true, AddOwner, DecisionOptOut},
+		"subresource":    {v1.AdmissionRequest{Namespace: "default", Resource: resourcePods, SubResource: "status", Object: tidePod()}, true, AddOwner, DecisionSubresource},
+		"other resource": {v1.AdmissionRequest{Namespace: "default", Resource: deployments, Object: tidePod()}, true, AddOwner, DecisionOtherResource},
+		"wrong resource": {v1.AdmissionRequest{Namespace: "default", Resource: deployments, Object: tidePod()}, false, AddOwner, DecisionWrongResource},
+		"invalid pod":    {v1.AdmissionRequest{Namespace: "default", Resource: resourcePods, Object: runtime.RawExtension{Raw: []byte(`[]`)}}, true, AddOwner, DecisionInvalidPod},
+	}
+
+	for name, tc := range cases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			IgnoreOtherResources = tc.ignoreOtherResources
+			defer func() { IgnoreOtherResources = true }()
+			buf := captureLog(t)
+			req := tc.req
+			_, _ = reviewPod("/labels/owner", &req, tc.apply, DefaultFailPolicy)
+			want := "status=audit path=/labels/owner uid= namespace=" + req.Namespace + " operation= decision=" + tc.decision + "\n"
+			if !strings.Contains(buf.String(), want) {
+				t.Errorf("log=%q, want %q", buf.String(), want)
+			}
+		})
+	}
+}
+
+func Test_audit_disabled(t *testing.T) {
+	buf := captureLog(t)
+	_, _ = Review(&v1.AdmissionRequest{Namespace: "default", Resource: resourcePods, Object: tidePod()}, AddOwner)
+	if strings.Contains(buf.String(), "status=audit") {
+		t.Errorf("log=%q, want no audit record", buf.String())
+	}
+}
@@ -0,0 +1,58 @@
+package majortom
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+var ErrBreakerOpen = fmt.Errorf("circuit breaker open")
+
+// Breaker short-circuits calls for Cooldown after Threshold consecutive failures so a slow or failing dependency
+// doesn't slow every admission request. Once the cooldown elapses calls are permitted again, a success closes the
+// breaker and a failure opens it for another cooldown.
+type Breaker struct {
+	Threshold int
+	Cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	now       func() time.Time
+}
+
+// NewBreaker returns a closed breaker.
+func NewBreaker(threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{Threshold: threshold, Cooldown: cooldown, now: time.Now}
+}
+
+// Do calls fn unless the breaker is open in which case ErrBreakerOpen is returned.
+func (b *Breaker) Do(fn func() error) error {
+	b.mu.Lock()
+	if b.now().Before(b.openUntil) {
+		b.mu.Unlock()
+		return ErrBreakerOpen
+	}
+	b.mu.Unlock()
+
+	err := fn()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil {
+		b.failures = 0
+		return nil
+	}
+	b.failures++
+	if b.failures >= b.Threshold {
+		b.openUntil = b.now().Add(b.Cooldown)
+	}
+	return err
+}
+
+// Open reports whether calls are currently being short-circuited.
+func (b *Breaker) Open() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.now().Before(b.openUntil)
+}
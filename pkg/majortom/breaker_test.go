@@ -0,0 +1,127 @@
+package majortom
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+var errLookup = fmt.Errorf("apiserver timeout")
+
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.t
+}
+
+func newTestBreaker(threshold int) (*Breaker, *fakeClock) {
+	clock := &fakeClock{t: time.Unix(0, 0)}
+	b := NewBreaker(threshold, time.Minute)
+	b.now = clock.now
+	return b, clock
+}
+
+func failing() error {
+	return errLookup
+}
+
+func succeeding() error {
+	return nil
+}
+
+func Test_Breaker_trips_after_threshold(t *testing.T) {
+	b, _ := newTestBreaker(3)
+	for i := 0; i < 3; i++ {
+		err := b.Do(failing)
+		if err != errLookup {
+			t.Errorf("Do()[%d]=%v, want errLookup", i, err)
+		}
+	}
+	err := b.Do(succeeding)
+	if err != ErrBreakerOpen {
+		t.Errorf("Do()=%v, want ErrBreakerOpen", err)
+	}
+}
+
+func Test_Breaker_success_resets_failures(t *testing.T) {
+	b, _ := newTestBreaker(2)
+	_ = b.Do(failing)
+	_ = b.Do(succeeding)
+	_ = b.Do(failing)
+	if b.Open() {
+		t.Error("b.Open()=true, want false")
+	}
+}
+
+func Test_Breaker_resets_after_cooldown(t *testing.T) {
+	b, clock := newTestBreaker(1)
+	_ = b.Do(failing)
+	if !b.Open() {
+		t.Fatal("b.Open()=false, want true")
+	}
+
+	clock.t = clock.t.Add(time.Minute)
+	err := b.Do(succeeding)
+	if err != nil {
+		t.Errorf("Do()=%v, want nil", err)
+	}
+	if b.Open() {
+		t.Error("b.Open()=true, want false")
+	}
+}
+
+func Test_Breaker_reopens_when_trial_fails(t *testing.T) {
+	b, clock := newTestBreaker(2)
+	_ = b.Do(failing)
+	_ = b.Do(failing)
+	clock.t = clock.t.Add(time.Minute)
+	_ = b.Do(failing)
+	if !b.Open() {
+		t.Error("b.Open()=false, want true")
+	}
+}
+
+type failingNamespaces struct {
+	calls int
+}
+
+func (f *failingNamespaces) Get(string) (*corev1.Namespace, error) {
+	f.calls++
+	return nil, errLookup
+}
+
+func Test_namespace_lookup_short_circuits(t *testing.T) {
+	b, _ := newTestBreaker(2)
+	old := lookupBreaker
+	lookupBreaker = b
+	defer func() { lookupBreaker = old }()
+	f := &failingNamespaces{}
+	Namespaces = f
+	defer func() { Namespaces = nil }()
+
+	for i := 0; i < 4; i++ {
+		if isExcludedByLabel("default") {
+			t.Errorf("isExcludedByLabel()[%d]=true, want false", i)
+		}
+	}
+	if f.calls != 2 {
+		t.Errorf("f.calls=%v, want 2", f.calls)
+	}
+}
+
+func Test_namespace_not_found_does_not_trip(t *testing.T) {
+	b, _ := newTestBreaker(1)
+	old := lookupBreaker
+	lookupBreaker = b
+	defer func() { lookupBreaker = old }()
+	withNamespaces(t)
+
+	isExcludedByLabel("missing")
+	if b.Open() {
+		t.Error("b.Open()=true, want false")
+	}
+}
@@ -0,0 +1,59 @@
+package majortom
+
+import (
+	"net/http"
+	"sync"
+
+	"k8s.io/client-go/tools/cache"
+)
+
+// CacheSync gates readiness on the informer caches having synced so decisions depending on in-cluster lookups
+// aren't made against empty caches.
+type CacheSync struct {
+	mu     sync.Mutex
+	synced []cache.InformerSynced
+	ready  bool
+}
+
+// cacheSync tracks the informers started by startInformers.
+var cacheSync = &CacheSync{}
+
+// Add registers informers that must sync before Wait returns.
+func (cs *CacheSync) Add(synced ...cache.InformerSynced) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.synced = append(cs.synced, synced...)
+}
+
+// Wait blocks until all added informers have synced or stop is closed, it reports whether they synced.
+func (cs *CacheSync) Wait(stop <-chan struct{}) bool {
+	cs.mu.Lock()
+	synced := cs.synced
+	cs.mu.Unlock()
+	if !cache.WaitForCacheSync(stop, synced...) {
+		return false
+	}
+	cs.mu.Lock()
+	cs.ready = true
+	cs.mu.Unlock()
+	LevelInfo.Printf("status=synced informers=%d", len(synced))
+	return true
+}
+
+// Ready reports whether Wait has observed all informers synced.
+func (cs *CacheSync) Ready() bool {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	return cs.ready
+}
+
+// Readyz returns a readiness handler that fails until the informers have synced.
+func (cs *CacheSync) Readyz() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !cs.Ready() {
+			http.Error(w, "informers not synced", http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("ok\n"))
+	})
+}
@@ -0,0 +1,76 @@
+package majortom
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func readyz(cs *CacheSync) int {
+	w := httptest.NewRecorder()
+	cs.Readyz().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	return w.Code
+}
+
+func Test_CacheSync_ready_once_synced(t *testing.T) {
+	var synced int32
+	cs := &CacheSync{}
+	cs.Add(func() bool { return atomic.LoadInt32(&synced) == 1 })
+
+	stop := make(chan struct{})
+	defer close(stop)
+	done := make(chan bool)
+	go func() { done <- cs.Wait(stop) }()
+
+	if readyz(cs) != http.StatusServiceUnavailable {
+		t.Errorf("readyz before sync=%v, want StatusServiceUnavailable", readyz(cs))
+	}
+	atomic.StoreInt32(&synced, 1)
+	if !<-done {
+		t.Fatal("Wait=false, want true")
+	}
+	if readyz(cs) != http.StatusOK {
+		t.Errorf("readyz after sync=%v, want StatusOK", readyz(cs))
+	}
+}
+
+func Test_CacheSync_not_ready_when_stopped(t *testing.T) {
+	cs := &CacheSync{}
+	cs.Add(func() bool { return false })
+	stop := make(chan struct{})
+	close(stop)
+	if cs.Wait(stop) {
+		t.Error("Wait=true, want false")
+	}
+	if readyz(cs) != http.StatusServiceUnavailable {
+		t.Errorf("readyz=%v, want StatusServiceUnavailable", readyz(cs))
+	}
+}
+
+func Test_CacheSync_without_informers_is_ready(t *testing.T) {
+	cs := &CacheSync{}
+	if !cs.Wait(make(chan struct{})) {
+		t.Error("Wait=false, want true")
+	}
+	if readyz(cs) != http.StatusOK {
+		t.Errorf("readyz=%v, want StatusOK", readyz(cs))
+	}
+}
+
+func Test_readyz_fails_while_draining(t *testing.T) {
+	d := &Drainer{}
+	h := d.Wrap((&CacheSync{ready: true}).Readyz())
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("w.Code=%v, want StatusOK", w.Code)
+	}
+
+	d.Begin()
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("w.Code=%v, want StatusServiceUnavailable", w.Code)
+	}
+}
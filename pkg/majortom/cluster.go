@@ -0,0 +1,171 @@
+package majortom
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// ExcludeLabel on a namespace with the value "true" prevents majortom mutating pods within it.
+const ExcludeLabel = "majortom.io/exclude"
+
+// ResyncPeriod is how often the informer caches are resynced with the apiserver.
+const ResyncPeriod = 10 * time.Minute
+
+// NamespaceGetter retrieves namespaces, it is satisfied by the client-go NamespaceLister.
+type NamespaceGetter interface {
+	Get(name string) (*corev1.Namespace, error)
+}
+
+// Namespaces is consulted for namespace labels, it is nil when in-cluster lookups are unavailable.
+var Namespaces NamespaceGetter
+
+// NodeGetter retrieves nodes, it is satisfied by the client-go NodeLister.
+type NodeGetter interface {
+	Get(name string) (*corev1.Node, error)
+}
+
+// Nodes is consulted for node labels, it is nil when in-cluster lookups are unavailable.
+var Nodes NodeGetter
+
+// lookupBreaker guards in-cluster lookups, when open lookup dependent behaviour is skipped.
+var lookupBreaker = NewBreaker(5, 30*time.Second)
+
+// lookup calls fn through the lookupBreaker, not found is an answer rather than a failure so doesn't trip it.
+func lookup(fn func() error) error {
+	var notFound error
+	err := lookupBreaker.Do(func() error {
+		err := fn()
+		if apierrors.IsNotFound(err) {
+			notFound = err
+			return nil
+		}
+		return err
+	})
+	if notFound != nil {
+		return notFound
+	}
+	return err
+}
+
+// startInformers starts the in-cluster caches, lookups fall back to static behaviour when not running in a cluster.
+func startInformers(stop <-chan struct{}) {
+	cfg, err := rest.InClusterConfig()
+	if err != nil {
+		LevelWarn.Printf("status=standalone err='in-cluster config: %v'", err)
+		return
+	}
+	cs, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		LevelWarn.Printf("status=standalone err='clientset: %v'", err)
+		return
+	}
+	factory := informers.NewSharedInformerFactory(cs, ResyncPeriod)
+	namespaces := factory.Core().V1().Namespaces()
+	nodes := factory.Core().V1().Nodes()
+	Namespaces = namespaces.Lister()
+	Nodes = nodes.Lister()
+	ConfigMaps = clientConfigMaps{cs}
+	cacheSync.Add(namespaces.Informer().HasSynced, nodes.Informer().HasSynced)
+	factory.Start(stop)
+}
+
+// isExcludedByLabel reports whether the namespace carries the ExcludeLabel.
+func isExcludedByLabel(namespace string) bool {
+	if Namespaces == nil {
+		return false
+	}
+	var ns *corev1.Namespace
+	err := lookup(func() error {
+		var err error
+		ns, err = Namespaces.Get(namespace)
+		return err
+	})
+	if err != nil {
+		LevelDebug.Printf("status=fallback namespace=%s err='namespace lookup: %v'", namespace, err)
+		return false
+	}
+	return ns.Labels[ExcludeLabel] == "true"
+}
+
+// TenantKey is both the namespace annotation naming a tenant and the pod label TenantPatch keeps equal to it.
+const TenantKey = "tenant"
+
+// ErrNamespaceUnavailable is returned when a namespace can't be looked up, e.g. outside a cluster, before the informer
+// has synced or while the lookupBreaker is open.
+var ErrNamespaceUnavailable = fmt.Errorf("namespace unavailable")
+
+// TenantPatch sets the pod's tenant label to the tenant annotation of its namespace, overwriting any other value so
+// pods can't claim another tenant. The namespace is taken from the request as the pod's may be unset on CREATE.
+// Namespaces without the annotation are skipped with a warning. A namespace that can't be looked up is an error
+// rather than a skip so a forged label isn't admitted, the route's fail policy decides the response.
+func TenantPatch() RequestPatchable {
+	return func(req *v1.AdmissionRequest, pod *corev1.Pod) ([]operation, error) {
+		if Namespaces == nil {
+			return nil, fmt.Errorf("%w: %s: no in-cluster lookups", ErrNamespaceUnavailable, req.Namespace)
+		}
+		var ns *corev1.Namespace
+		err := lookup(func() error {
+			var err error
+			ns, err = Namespaces.Get(req.Namespace)
+			return err
+		})
+		if errors.Is(err, ErrBreakerOpen) {
+			return nil, fmt.Errorf("namespace %s: %w", req.Namespace, err)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrNamespaceUnavailable, req.Namespace, err)
+		}
+		tenant, ok := ns.Annotations[TenantKey]
+		if !ok {
+			LevelWarn.Printf("status=skipped namespace=%s err='namespace has no %s annotation'", req.Namespace, TenantKey)
+			return nil, nil
+		}
+		existing, ok := pod.ObjectMeta.Labels[TenantKey]
+		if ok && existing == tenant {
+			return nil, nil
+		}
+		if ok {
+			return []operation{replaceOp(labelsPath+"/"+TenantKey, tenant)}, nil
+		}
+		return mapEntriesOps(labelsPath, pod.ObjectMeta.Labels, map[string]string{TenantKey: tenant}), nil
+	}
+}
+
+// ZoneLabel is the well-known node label holding the node's zone.
+const ZoneLabel = "topology.kubernetes.io/zone"
+
+// ZoneVarPatch injects the zone of the pod's node as the literal env var name. The downward API can't expose node
+// labels so the node is looked up, which requires spec.nodeName. Pods are normally bound after CREATE and container
+// env is immutable thereafter so this only applies to pods created with a nodeName. Unbound pods, missing nodes and
+// unlabeled nodes are skipped.
+func ZoneVarPatch(name string, opts ...VarOption) PodPatchable {
+	return func(pod *corev1.Pod) ([]operation, error) {
+		nodeName := pod.Spec.NodeName
+		if nodeName == "" || Nodes == nil {
+			return nil, nil
+		}
+		var node *corev1.Node
+		err := lookup(func() error {
+			var err error
+			node, err = Nodes.Get(nodeName)
+			return err
+		})
+		if err != nil {
+			LevelDebug.Printf("status=fallback node=%s err='node lookup: %v'", nodeName, err)
+			return nil, nil
+		}
+		zone, ok := node.Labels[ZoneLabel]
+		if !ok {
+			return nil, nil
+		}
+		return EnvPatch(corev1.EnvVar{Name: name, Value: zone}, opts...)(pod)
+	}
+}
@@ -0,0 +1,198 @@
+package majortom
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type fakeNamespaces map[string]*corev1.Namespace
+
+func (f fakeNamespaces) Get(name string) (*corev1.Namespace, error) {
+	ns, ok := f[name]
+	if !ok {
+		return nil, apierrors.NewNotFound(corev1.Resource("namespace"), name)
+	}
+	return ns, nil
+}
+
+func withNamespaces(t *testing.T, namespaces ...*corev1.Namespace) {
+	f := fakeNamespaces{}
+	for _, ns := range namespaces {
+		f[ns.Name] = ns
+	}
+	Namespaces = f
+	t.Cleanup(func() { Namespaces = nil })
+}
+
+func namespace(name string, labels map[string]string) *corev1.Namespace {
+	return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+}
+
+func Test_isExcludedByLabel(t *testing.T) {
+	withNamespaces(t,
+		namespace("excluded", map[string]string{ExcludeLabel: "true"}),
+		namespace("included", map[string]string{ExcludeLabel: "false"}),
+		namespace("unlabeled", nil),
+	)
+	cases := map[string]bool{
+		"excluded":  true,
+		"included":  false,
+		"unlabeled": false,
+		"missing":   false,
+	}
+
+	for ns, expected := range cases {
+		actual := isExcludedByLabel(ns)
+		if actual != expected {
+			t.Errorf("isExcludedByLabel(%q)=%v, want %v", ns, actual, expected)
+		}
+	}
+}
+
+func Test_isExcludedByLabel_without_lister(t *testing.T) {
+	Namespaces = nil
+	if isExcludedByLabel("default") {
+		t.Error("isExcludedByLabel(`default`)=true, want false")
+	}
+}
+
+func Test_excluded_namespace_should_not_be_mutated(t *testing.T) {
+	withNamespaces(t, namespace("excluded", map[string]string{ExcludeLabel: "true"}))
+	r := post(&v1.AdmissionReview{Request: &v1.AdmissionRequest{Namespace: "excluded", Resource: resourcePods, Object: tidePod()}})
+	w := httptest.NewRecorder()
+	podPatch(w, r, AddOwner, DefaultFailPolicy)
+	if w.Code != http.StatusOK {
+		t.Fatalf("w.Code=%v, want StatusOK", w.Code)
+	}
+	resp := decodeResponse(t, w)
+	if !resp.Allowed || resp.Patch != nil {
+		t.Errorf("resp=%#v, want allowed without patch", resp)
+	}
+}
+
+type fakeNodes map[string]*corev1.Node
+
+func (f fakeNodes) Get(name string) (*corev1.Node, error) {
+	node, ok := f[name]
+	if !ok {
+		return nil, apierrors.NewNotFound(corev1.Resource("node"), name)
+	}
+	return node, nil
+}
+
+func Test_ZoneVarPatch(t *testing.T) {
+	Nodes = fakeNodes{
+		"worker-a": {ObjectMeta: metav1.ObjectMeta{Name: "worker-a", Labels: map[string]string{ZoneLabel: "us-east-1a"}}},
+		"worker-b": {ObjectMeta: metav1.ObjectMeta{Name: "worker-b"}},
+	}
+	defer func() { Nodes = nil }()
+	cases := map[string]struct {
+		nodeName string
+		zone     string
+	}{
+		"zoned node":     {"worker-a", "us-east-1a"},
+		"unzoned node":   {"worker-b", ""},
+		"missing node":   {"worker-c", ""},
+		"unbound create": {"", ""},
+	}
+
+	for n, tc := range cases {
+		tc := tc
+		t.Run(n, func(t *testing.T) {
+			pod := &corev1.Pod{Spec: corev1.PodSpec{NodeName: tc.nodeName, Containers: []corev1.Container{{Image: "nginx:latest"}}}}
+			ops, err := ZoneVarPatch("ZONE")(pod)
+			if err != nil {
+				t.Fatalf("err=%v, want nil", err)
+			}
+			patched := applyPatch(t, pod, ops)
+			env := patched.Spec.Containers[0].Env
+			if tc.zone == "" {
+				if len(env) != 0 {
+					t.Errorf("env=%v, want none", env)
+				}
+				return
+			}
+			if len(env) != 1 || env[0].Name != "ZONE" || env[0].Value != tc.zone {
+				t.Errorf("env=%v, want ZONE=%v", env, tc.zone)
+			}
+		})
+	}
+}
+
+func Test_TenantPatch(t *testing.T) {
+	withNamespaces(t,
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "acme", Annotations: map[string]string{TenantKey: "acme-corp"}}},
+		namespace("shared", nil),
+	)
+	cases := map[string]struct {
+		namespace string
+		labels    map[string]string
+		ops       int
+		tenant    string
+	}{
+		"absent label":   {"acme", nil, 1, "acme-corp"},
+		"other labels":   {"acme", map[string]string{"app": "tide"}, 1, "acme-corp"},
+		"mislabelled":    {"acme", map[string]string{TenantKey: "globex"}, 1, "acme-corp"},
+		"matching label": {"acme", map[string]string{TenantKey: "acme-corp"}, 0, "acme-corp"},
+		"no annotation":  {"shared", map[string]string{TenantKey: "globex"}, 0, "globex"},
+	}
+
+	for n, tc := range cases {
+		tc := tc
+		t.Run(n, func(t *testing.T) {
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Labels: tc.labels}}
+			ops, err := TenantPatch()(&v1.AdmissionRequest{Namespace: tc.namespace}, pod)
+			if err != nil {
+				t.Fatalf("err=%v, want nil", err)
+			}
+			if len(ops) != tc.ops {
+				t.Errorf("len(ops)=%v, want %v", len(ops), tc.ops)
+			}
+			patched := applyPatch(t, pod, ops)
+			if patched.Labels[TenantKey] != tc.tenant {
+				t.Errorf("labels[tenant]=%v, want %v", patched.Labels[TenantKey], tc.tenant)
+			}
+		})
+	}
+}
+
+func Test_TenantPatch_fails_closed(t *testing.T) {
+	forged := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{TenantKey: "globex"}}}
+	cases := map[string]struct {
+		setup func(t *testing.T)
+		err   error
+	}{
+		"standalone":        {func(*testing.T) {}, ErrNamespaceUnavailable},
+		"missing namespace": {func(t *testing.T) { withNamespaces(t) }, ErrNamespaceUnavailable},
+		"breaker open": {func(t *testing.T) {
+			withNamespaces(t)
+			open := lookupBreaker
+			lookupBreaker = NewBreaker(1, time.Minute)
+			t.Cleanup(func() { lookupBreaker = open })
+			_ = lookupBreaker.Do(func() error { return fmt.Errorf("apiserver unavailable") })
+		}, ErrBreakerOpen},
+	}
+
+	for n, tc := range cases {
+		tc := tc
+		t.Run(n, func(t *testing.T) {
+			tc.setup(t)
+			ops, err := TenantPatch()(&v1.AdmissionRequest{Namespace: "acme"}, forged)
+			if !errors.Is(err, tc.err) {
+				t.Errorf("err=%v, want %v", err, tc.err)
+			}
+			if ops != nil {
+				t.Errorf("ops=%v, want nil", ops)
+			}
+		})
+	}
+}
@@ -0,0 +1,391 @@
+package majortom
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+// Config describes the admission routes majortom serves.
+type Config struct {
+	Routes []RouteConfig `json:"routes"`
+	// Profiles are bundles of patchables pods select with the ProfileLabel on routes with profiles enabled.
+	Profiles map[string]ProfileConfig `json:"profiles,omitempty"`
+}
+
+// ProfileConfig describes the patchables applied to pods selecting a profile, in field order.
+type ProfileConfig struct {
+	Labels map[string]string `json:"labels,omitempty"`
+	// Vars maps env var names to the downward API fieldPath they reference.
+	Vars map[string]string `json:"vars,omitempty"`
+	// Resources are defaults for containers that don't specify them, see ResourceDefaultsPatch.
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+}
+
+// RouteConfig describes the patchables applied by a single admission route. Patchables are applied in field order.
+type RouteConfig struct {
+	Path        string            `json:"path"`
+	Owner       string            `json:"owner,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// Vars maps env var names to the downward API fieldPath they reference.
+	Vars map[string]string `json:"vars,omitempty"`
+	// ImageEnv injects env into containers by image, see ImageEnvPatch.
+	ImageEnv []ImageEnvConfig `json:"imageEnv,omitempty"`
+	// MaxResources caps the cpu and memory limits of containers, see ClampResourceListPatch.
+	MaxResources corev1.ResourceList `json:"maxResources,omitempty"`
+	// FailPolicy overrides the DefaultFailPolicy when the route's patchables fail.
+	FailPolicy *FailPolicy `json:"failPolicy,omitempty"`
+	// Recommend when set is returned as a warning alongside any patch, see Router.HandleRecommend.
+	Recommend string `json:"recommend,omitempty"`
+	// Profiles applies the profile selected by the pod after the route's other patchables, see ProfilePatch.
+	Profiles bool `json:"profiles,omitempty"`
+}
+
+// ImageEnvConfig is the env injected into containers whose image matches the regular expression Image.
+type ImageEnvConfig struct {
+	Image string `json:"image"`
+	// Env maps env var names to their literal values.
+	Env map[string]string `json:"env"`
+}
+
+// LoadConfig reads and validates the YAML config at path. When path is a directory every *.yaml file is read in
+// filename order and their routes merged, see LoadConfigDir.
+func LoadConfig(path string) (*Config, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return LoadConfigDir(path)
+	}
+	cfg, err := readConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	err = cfg.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// LoadConfigDir merges the routes of every *.yaml file in dir (e.g. /etc/majortom/conf.d) so teams can own their own
+// file. Files are merged in filename order and a path defined by more than one file is an error.
+func LoadConfigDir(dir string) (*Config, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("config %s: no *.yaml files", dir)
+	}
+	sort.Strings(files)
+
+	merged := &Config{}
+	definedIn := map[string]string{}
+	profileIn := map[string]string{}
+	for _, file := range files {
+		cfg, err := readConfig(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, rc := range cfg.Routes {
+			prev, ok := definedIn[rc.Path]
+			if ok {
+				return nil, fmt.Errorf("config %s: path %q already defined in %s", file, rc.Path, prev)
+			}
+			definedIn[rc.Path] = file
+			merged.Routes = append(merged.Routes, rc)
+		}
+		for name, pc := range cfg.Profiles {
+			prev, ok := profileIn[name]
+			if ok {
+				return nil, fmt.Errorf("config %s: profile %q already defined in %s", file, name, prev)
+			}
+			profileIn[name] = file
+			if merged.Profiles == nil {
+				merged.Profiles = map[string]ProfileConfig{}
+			}
+			merged.Profiles[name] = pc
+		}
+	}
+	err = merged.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("config %s: %w", dir, err)
+	}
+	return merged, nil
+}
+
+func readConfig(path string) (*Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	err = yaml.UnmarshalStrict(b, &cfg)
+	if err != nil {
+		return nil, fmt.Errorf("config %s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+// Validate returns an error describing the first invalid route.
+func (cfg *Config) Validate() error {
+	if len(cfg.Routes) == 0 {
+		return fmt.Errorf("no routes defined")
+	}
+	seen := map[string]bool{}
+	for i, rc := range cfg.Routes {
+		if !strings.HasPrefix(rc.Path, "/") {
+			return fmt.Errorf("routes[%d] path %q must start with /", i, rc.Path)
+		}
+		if seen[rc.Path] {
+			return fmt.Errorf("routes[%d] path %q defined more than once", i, rc.Path)
+		}
+		seen[rc.Path] = true
+		err := rc.Validate()
+		if err != nil {
+			return fmt.Errorf("routes[%d] %s: %w", i, rc.Path, err)
+		}
+		if rc.Profiles && len(cfg.Profiles) == 0 {
+			return fmt.Errorf("routes[%d] %s: profiles enabled without profiles defined", i, rc.Path)
+		}
+	}
+	for name, pc := range cfg.Profiles {
+		err := pc.Validate()
+		if err != nil {
+			return fmt.Errorf("profiles %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Validate returns an error when the profile has no patchables or invalid values.
+func (pc *ProfileConfig) Validate() error {
+	if len(pc.Labels) == 0 && len(pc.Vars) == 0 && pc.Resources == nil {
+		return fmt.Errorf("no patchables defined")
+	}
+	err := validateLabels(pc.Labels)
+	if err != nil {
+		return err
+	}
+	return validateVars(pc.Vars)
+}
+
+// Patchable composes the profile's configured patchables.
+func (pc *ProfileConfig) Patchable() PodPatchable {
+	var ps []PodPatchable
+	if len(pc.Labels) > 0 {
+		ps = append(ps, LabelsPatch(pc.Labels))
+	}
+	if len(pc.Vars) > 0 {
+		ps = append(ps, VarsPatch(pc.Vars))
+	}
+	if pc.Resources != nil {
+		ps = append(ps, ResourceDefaultsPatch(*pc.Resources))
+	}
+	return Chain(ps...)
+}
+
+// ProfilePatch selects between the configured profiles, see ProfilePatch.
+func (cfg *Config) ProfilePatch() PodPatchable {
+	profiles := make(map[string]PodPatchable, len(cfg.Profiles))
+	for name := range cfg.Profiles {
+		pc := cfg.Profiles[name]
+		profiles[name] = pc.Patchable()
+	}
+	return ProfilePatch(profiles)
+}
+
+// Validate returns an error when the route has no patchables or invalid values.
+func (rc *RouteConfig) Validate() error {
+	if rc.Owner == "" && len(rc.Labels) == 0 && len(rc.Annotations) == 0 && len(rc.Vars) == 0 && len(rc.ImageEnv) == 0 && len(rc.MaxResources) == 0 && !rc.Profiles {
+		return fmt.Errorf("no patchables defined")
+	}
+	if rc.Owner != "" {
+		errs := validation.IsValidLabelValue(rc.Owner)
+		if len(errs) > 0 {
+			return fmt.Errorf("owner %q: %s", rc.Owner, strings.Join(errs, "; "))
+		}
+	}
+	err := validateLabels(rc.Labels)
+	if err != nil {
+		return err
+	}
+	for k := range rc.Annotations {
+		errs := validation.IsQualifiedName(strings.ToLower(k))
+		if len(errs) > 0 {
+			return fmt.Errorf("annotation %s: %s", k, strings.Join(errs, "; "))
+		}
+	}
+	err = validateVars(rc.Vars)
+	if err != nil {
+		return err
+	}
+	for i, ic := range rc.ImageEnv {
+		err := ic.Validate()
+		if err != nil {
+			return fmt.Errorf("imageEnv[%d]: %w", i, err)
+		}
+	}
+	for name := range rc.MaxResources {
+		if name != corev1.ResourceCPU && name != corev1.ResourceMemory {
+			return fmt.Errorf("maxResources %s: only cpu and memory can be capped", name)
+		}
+	}
+	return nil
+}
+
+// Validate returns an error when the image isn't a valid regular expression or the env is empty or invalid.
+func (ic *ImageEnvConfig) Validate() error {
+	_, err := regexp.Compile(ic.Image)
+	if err != nil {
+		return fmt.Errorf("image %q: %w", ic.Image, err)
+	}
+	if len(ic.Env) == 0 {
+		return fmt.Errorf("image %q: no env defined", ic.Image)
+	}
+	for name := range ic.Env {
+		errs := validation.IsEnvVarName(name)
+		if len(errs) > 0 {
+			return fmt.Errorf("env %s: %s", name, strings.Join(errs, "; "))
+		}
+	}
+	return nil
+}
+
+// imageEnvPatch is the ImageEnvPatch of the route's rules, failing when an image isn't a valid regular expression.
+func (rc *RouteConfig) imageEnvPatch() PodPatchable {
+	rules := make([]ImageEnv, 0, len(rc.ImageEnv))
+	for _, ic := range rc.ImageEnv {
+		re, err := regexp.Compile(ic.Image)
+		if err != nil {
+			return func(*corev1.Pod) ([]operation, error) {
+				return nil, fmt.Errorf("image %q: %w", ic.Image, err)
+			}
+		}
+		rules = append(rules, ImageEnv{Pattern: re, Env: ic.Env})
+	}
+	return ImageEnvPatch(rules...)
+}
+
+func validateLabels(labels map[string]string) error {
+	for k, v := range labels {
+		errs := append(validation.IsQualifiedName(k), validation.IsValidLabelValue(v)...)
+		if len(errs) > 0 {
+			return fmt.Errorf("label %s=%s: %s", k, v, strings.Join(errs, "; "))
+		}
+	}
+	return nil
+}
+
+func validateVars(vars map[string]string) error {
+	for name, fieldPath := range vars {
+		errs := validation.IsEnvVarName(name)
+		if len(errs) > 0 {
+			return fmt.Errorf("var %s: %s", name, strings.Join(errs, "; "))
+		}
+		err := checkFieldPath(fieldPath)
+		if err != nil {
+			return fmt.Errorf("var %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Patchable composes the route's configured patchables, profiles are applied by the Config's Router.
+func (rc *RouteConfig) Patchable() PodPatchable {
+	return rc.patchable(nil)
+}
+
+// patchable composes the route's configured patchables followed by profiles when it isn't nil.
+func (rc *RouteConfig) patchable(profiles PodPatchable) PodPatchable {
+	var ps []PodPatchable
+	if rc.Owner != "" {
+		ps = append(ps, OwnerPatch(rc.Owner))
+	}
+	if len(rc.Labels) > 0 {
+		ps = append(ps, LabelsPatch(rc.Labels))
+	}
+	if len(rc.Annotations) > 0 {
+		ps = append(ps, AnnotationsPatch(rc.Annotations))
+	}
+	if len(rc.Vars) > 0 {
+		ps = append(ps, VarsPatch(rc.Vars))
+	}
+	if len(rc.ImageEnv) > 0 {
+		ps = append(ps, rc.imageEnvPatch())
+	}
+	if len(rc.MaxResources) > 0 {
+		ps = append(ps, ClampResourceListPatch(rc.MaxResources))
+	}
+	if profiles != nil {
+		ps = append(ps, profiles)
+	}
+	if rc.FailPolicy != nil {
+		return WithFailPolicy(*rc.FailPolicy, Chain(ps...))
+	}
+	return Chain(ps...)
+}
+
+// describe names the route's configured patchables in the order they're applied.
+func (rc *RouteConfig) describe() string {
+	var names []string
+	if rc.Owner != "" {
+		names = append(names, "owner")
+	}
+	if len(rc.Labels) > 0 {
+		names = append(names, "labels")
+	}
+	if len(rc.Annotations) > 0 {
+		names = append(names, "annotations")
+	}
+	if len(rc.Vars) > 0 {
+		names = append(names, "vars")
+	}
+	if len(rc.ImageEnv) > 0 {
+		names = append(names, "imageEnv")
+	}
+	if len(rc.MaxResources) > 0 {
+		names = append(names, "maxResources")
+	}
+	if rc.Profiles {
+		names = append(names, "profiles")
+	}
+	return strings.Join(names, "+")
+}
+
+// Router registers each configured route.
+func (cfg *Config) Router() *Router {
+	rt := NewRouter()
+	var profiles PodPatchable
+	if len(cfg.Profiles) > 0 {
+		profiles = cfg.ProfilePatch()
+	}
+	for i := range cfg.Routes {
+		rc := &cfg.Routes[i]
+		p := rc.Patchable()
+		if rc.Profiles {
+			p = rc.patchable(profiles)
+		}
+		if rc.FailPolicy != nil {
+			rt.policies[rc.Path] = *rc.FailPolicy
+		}
+		if rc.Recommend != "" {
+			rt.HandleRecommend(rc.Path, p, rc.Recommend)
+		} else {
+			rt.Handle(rc.Path, p)
+		}
+		rt.Describe(rc.Path, rc.describe())
+	}
+	return rt
+}
@@ -0,0 +1,360 @@
+package majortom
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const ownerConfig = `
+routes:
+  - path: /labels/owner
+    owner: nathan.fisher
+    labels:
+      team: platform
+    vars:
+      NODEIP: status.hostIP
+`
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "majortom")
+	if err != nil {
+		t.Fatalf("TempDir err=%v, want nil", err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	return dir
+}
+
+func writeConfig(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	err := ioutil.WriteFile(path, []byte(content), 0600)
+	if err != nil {
+		t.Fatalf("WriteFile err=%v, want nil", err)
+	}
+	return path
+}
+
+func Test_LoadConfig(t *testing.T) {
+	path := writeConfig(t, tempDir(t), "majortom.yaml", ownerConfig)
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig err=%v, want nil", err)
+	}
+	if len(cfg.Routes) != 1 || cfg.Routes[0].Path != "/labels/owner" {
+		t.Fatalf("cfg.Routes=%#v, want /labels/owner", cfg.Routes)
+	}
+
+	pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Image: "nginx:latest"}}}}
+	ops, err := cfg.Routes[0].Patchable()(pod)
+	if err != nil {
+		t.Fatalf("Patchable err=%v, want nil", err)
+	}
+	patched := applyPatch(t, pod, ops)
+	if patched.Labels["owner"] != "nathan.fisher" || patched.Labels["team"] != "platform" {
+		t.Errorf("labels=%v, want owner and team", patched.Labels)
+	}
+	if len(patched.Spec.Containers[0].Env) != 1 {
+		t.Errorf("len(env)=%v, want 1", len(patched.Spec.Containers[0].Env))
+	}
+}
+
+func Test_LoadConfig_invalid(t *testing.T) {
+	cases := map[string]struct {
+		content string
+		err     string
+	}{
+		"no routes":       {"routes: []", "no routes defined"},
+		"unknown field":   {"routes:\n  - path: /a\n    owners: x", `unknown field "owners"`},
+		"relative path":   {"routes:\n  - path: a\n    owner: x", `path "a" must start with /`},
+		"duplicate path":  {"routes:\n  - path: /a\n    owner: x\n  - path: /a\n    owner: y", `path "/a" defined more than once`},
+		"no patchables":   {"routes:\n  - path: /a", "no patchables defined"},
+		"bad field path":  {"routes:\n  - path: /a\n    vars:\n      NODEIP: spec.containers", "invalid fieldPath"},
+		"bad label value": {"routes:\n  - path: /a\n    labels:\n      team: a b", "label team=a b"},
+	}
+
+	for n, tc := range cases {
+		tc := tc
+		t.Run(n, func(t *testing.T) {
+			path := writeConfig(t, tempDir(t), "majortom.yaml", tc.content)
+			_, err := LoadConfig(path)
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("err=%v, want containing %v", err, tc.err)
+			}
+		})
+	}
+}
+
+func Test_LoadConfig_directory(t *testing.T) {
+	dir := tempDir(t)
+	writeConfig(t, dir, "20-payments.yaml", "routes:\n  - path: /payments\n    labels:\n      team: payments")
+	writeConfig(t, dir, "10-platform.yaml", ownerConfig)
+	writeConfig(t, dir, "README.md", "not config")
+	cfg, err := LoadConfig(dir)
+	if err != nil {
+		t.Fatalf("LoadConfig err=%v, want nil", err)
+	}
+	var paths []string
+	for _, rc := range cfg.Routes {
+		paths = append(paths, rc.Path)
+	}
+	expected := []string{"/labels/owner", "/payments"}
+	if !cmp.Equal(paths, expected) {
+		t.Errorf("routes mismatch (+want -got)\n%s", cmp.Diff(paths, expected))
+	}
+	rt := cfg.Router()
+	if !cmp.Equal(rt.routes, expected) {
+		t.Errorf("registered routes mismatch (+want -got)\n%s", cmp.Diff(rt.routes, expected))
+	}
+}
+
+func Test_LoadConfig_directory_conflicting_paths(t *testing.T) {
+	dir := tempDir(t)
+	writeConfig(t, dir, "10-platform.yaml", ownerConfig)
+	writeConfig(t, dir, "20-payments.yaml", "routes:\n  - path: /labels/owner\n    owner: payments")
+	_, err := LoadConfig(dir)
+	if err == nil || !strings.Contains(err.Error(), "20-payments.yaml") || !strings.Contains(err.Error(), "already defined in") {
+		t.Errorf("err=%v, want conflict naming both files", err)
+	}
+}
+
+func Test_Chain_merges_labels_on_pod_without_labels(t *testing.T) {
+	pod := &corev1.Pod{}
+	ops, err := Chain(LabelsPatch(map[string]string{"team": "platform"}), OwnerPatch("nathan.fisher"))(pod)
+	if err != nil {
+		t.Fatalf("err=%v, want nil", err)
+	}
+	patched := applyPatch(t, pod, ops)
+	if patched.Labels["owner"] != "nathan.fisher" || patched.Labels["team"] != "platform" {
+		t.Errorf("labels=%v, want owner and team", patched.Labels)
+	}
+}
+
+func Test_Chain_returns_first_error(t *testing.T) {
+	pod := &corev1.Pod{}
+	_, err := Chain(LabelsPatch(map[string]string{"owner": "betty.boop"}), OwnerPatch("nathan.fisher"))(pod)
+	if err != ErrPodHasOwnerLabel {
+		t.Errorf("err=%v, want ErrPodHasOwnerLabel", err)
+	}
+}
+
+const profilesConfig = `
+profiles:
+  web:
+    labels:
+      tier: web
+    vars:
+      NODEIP: status.hostIP
+    resources:
+      requests:
+        cpu: 100m
+        memory: 128Mi
+  batch:
+    labels:
+      tier: batch
+routes:
+  - path: /profiles
+    owner: platform
+    profiles: true
+`
+
+func Test_Config_profiles(t *testing.T) {
+	cfg, err := LoadConfig(writeConfig(t, tempDir(t), "majortom.yaml", profilesConfig))
+	if err != nil {
+		t.Fatalf("LoadConfig err=%v, want nil", err)
+	}
+	rt := cfg.Router()
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{ProfileLabel: "web"}},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "nginx:1.19.2"}}},
+	}
+	ops, err := rt.patchables["/profiles"](pod)
+	if err != nil {
+		t.Fatalf("err=%v, want nil", err)
+	}
+	patched := applyPatch(t, pod, ops)
+	if patched.Labels["owner"] != "platform" || patched.Labels["tier"] != "web" {
+		t.Errorf("labels=%v, want owner platform and tier web", patched.Labels)
+	}
+	container := patched.Spec.Containers[0]
+	if len(container.Env) != 1 || container.Env[0].Name != "NODEIP" {
+		t.Errorf("env=%v, want NODEIP", container.Env)
+	}
+	cpu := container.Resources.Requests[corev1.ResourceCPU]
+	mem := container.Resources.Requests[corev1.ResourceMemory]
+	if cpu.String() != "100m" || mem.String() != "128Mi" {
+		t.Errorf("requests=%v, want cpu 100m and memory 128Mi", container.Resources.Requests)
+	}
+	if rt.Summary() != "/profiles:mutate[owner+profiles]" {
+		t.Errorf("summary()=%v, want /profiles:mutate[owner+profiles]", rt.Summary())
+	}
+}
+
+func Test_Config_profiles_selection(t *testing.T) {
+	cfg, err := LoadConfig(writeConfig(t, tempDir(t), "majortom.yaml", profilesConfig))
+	if err != nil {
+		t.Fatalf("LoadConfig err=%v, want nil", err)
+	}
+	p := cfg.Router().patchables["/profiles"]
+	cases := map[string]struct {
+		labels map[string]string
+		tier   string
+		err    error
+	}{
+		"batch":      {map[string]string{ProfileLabel: "batch"}, "batch", nil},
+		"no profile": {nil, "", nil},
+		"unknown":    {map[string]string{ProfileLabel: "gpu"}, "", ErrUnknownProfile},
+	}
+
+	for n, tc := range cases {
+		tc := tc
+		t.Run(n, func(t *testing.T) {
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Labels: tc.labels}}
+			ops, err := p(pod)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("err=%v, want %v", err, tc.err)
+			}
+			if err != nil {
+				return
+			}
+			patched := applyPatch(t, pod, ops)
+			if patched.Labels["tier"] != tc.tier {
+				t.Errorf("labels[tier]=%v, want %v", patched.Labels["tier"], tc.tier)
+			}
+		})
+	}
+}
+
+func Test_Config_profiles_invalid(t *testing.T) {
+	cases := map[string]struct {
+		content string
+		err     string
+	}{
+		"no profiles":   {"routes:\n  - path: /a\n    profiles: true", "profiles enabled without profiles defined"},
+		"empty profile": {"profiles:\n  web: {}\nroutes:\n  - path: /a\n    profiles: true", "profiles web: no patchables defined"},
+		"invalid label": {"profiles:\n  web:\n    labels:\n      tier: bad value\nroutes:\n  - path: /a\n    profiles: true", "profiles web: label tier=bad value"},
+	}
+
+	for n, tc := range cases {
+		tc := tc
+		t.Run(n, func(t *testing.T) {
+			_, err := LoadConfig(writeConfig(t, tempDir(t), "majortom.yaml", tc.content))
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("err=%v, want containing %q", err, tc.err)
+			}
+		})
+	}
+}
+
+func Test_Config_imageEnv(t *testing.T) {
+	cfg, err := LoadConfig(writeConfig(t, tempDir(t), "majortom.yaml", `
+routes:
+  - path: /runtimes
+    imageEnv:
+      - image: openjdk|temurin
+        env:
+          JAVA_OPTS: -XX:MaxRAMPercentage=75
+      - image: '^node:'
+        env:
+          NODE_OPTIONS: --max-old-space-size=512
+`))
+	if err != nil {
+		t.Fatalf("LoadConfig err=%v, want nil", err)
+	}
+	rt := cfg.Router()
+	if rt.Summary() != "/runtimes:mutate[imageEnv]" {
+		t.Errorf("summary()=%v, want /runtimes:mutate[imageEnv]", rt.Summary())
+	}
+	pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{
+		{Name: "web", Image: "node:14-alpine"},
+		{Name: "api", Image: "openjdk:11-jre"},
+	}}}
+	ops, err := rt.patchables["/runtimes"](pod)
+	if err != nil {
+		t.Fatalf("err=%v, want nil", err)
+	}
+	patched := applyPatch(t, pod, ops)
+	expected := []string{"NODE_OPTIONS", "JAVA_OPTS"}
+	for i, c := range patched.Spec.Containers {
+		if len(c.Env) != 1 || c.Env[0].Name != expected[i] {
+			t.Errorf("containers[%d] env=%v, want %v", i, c.Env, expected[i])
+		}
+	}
+}
+
+func Test_Config_imageEnv_invalid(t *testing.T) {
+	cases := map[string]struct {
+		content string
+		err     string
+	}{
+		"invalid image": {"routes:\n  - path: /a\n    imageEnv:\n      - image: 'openjdk('\n        env:\n          JAVA_OPTS: -Xmx1g", "imageEnv[0]: image \"openjdk(\""},
+		"no env":        {"routes:\n  - path: /a\n    imageEnv:\n      - image: openjdk", "imageEnv[0]: image \"openjdk\": no env defined"},
+		"invalid name":  {"routes:\n  - path: /a\n    imageEnv:\n      - image: openjdk\n        env:\n          '1JAVA': x", "imageEnv[0]: env 1JAVA"},
+	}
+
+	for n, tc := range cases {
+		tc := tc
+		t.Run(n, func(t *testing.T) {
+			_, err := LoadConfig(writeConfig(t, tempDir(t), "majortom.yaml", tc.content))
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("err=%v, want containing %q", err, tc.err)
+			}
+		})
+	}
+}
+
+func Test_Config_maxResources(t *testing.T) {
+	cfg, err := LoadConfig(writeConfig(t, tempDir(t), "majortom.yaml", `
+routes:
+  - path: /clamp
+    maxResources:
+      cpu: "2"
+      memory: 4Gi
+`))
+	if err != nil {
+		t.Fatalf("LoadConfig err=%v, want nil", err)
+	}
+	rt := cfg.Router()
+	if rt.Summary() != "/clamp:mutate[maxResources]" {
+		t.Errorf("summary()=%v, want /clamp:mutate[maxResources]", rt.Summary())
+	}
+	pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Resources: corev1.ResourceRequirements{
+		Limits: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("8")},
+	}}}}}
+	ops, err := rt.patchables["/clamp"](pod)
+	if err != nil {
+		t.Fatalf("err=%v, want nil", err)
+	}
+	patched := applyPatch(t, pod, ops)
+	actual := patched.Spec.Containers[0].Resources.Limits[corev1.ResourceCPU]
+	if actual.String() != "2" {
+		t.Errorf("limits[cpu]=%v, want 2", actual.String())
+	}
+}
+
+func Test_Config_maxResources_invalid(t *testing.T) {
+	cases := map[string]struct {
+		content string
+		err     string
+	}{
+		"invalid quantity": {"routes:\n  - path: /a\n    maxResources:\n      cpu: two", "quantities must match"},
+		"invalid resource": {"routes:\n  - path: /a\n    maxResources:\n      gpu: 1", "maxResources gpu: only cpu and memory can be capped"},
+	}
+
+	for n, tc := range cases {
+		tc := tc
+		t.Run(n, func(t *testing.T) {
+			_, err := LoadConfig(writeConfig(t, tempDir(t), "majortom.yaml", tc.content))
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("err=%v, want containing %q", err, tc.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package majortom
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"time"
+
+	v1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ConfigMapTimeout bounds a ConfigMap lookup so it can't consume the apiserver's webhook timeout.
+const ConfigMapTimeout = 2 * time.Second
+
+// ConfigMapGetter retrieves ConfigMaps.
+type ConfigMapGetter interface {
+	Get(namespace, name string) (*corev1.ConfigMap, error)
+}
+
+// ConfigMaps is consulted for ConfigMap data, it is nil when in-cluster lookups are unavailable.
+var ConfigMaps ConfigMapGetter
+
+// clientConfigMaps gets ConfigMaps directly from the apiserver, caching every ConfigMap in the cluster would cost
+// more than the occasional lookup.
+type clientConfigMaps struct {
+	cs kubernetes.Interface
+}
+
+func (c clientConfigMaps) Get(namespace, name string) (*corev1.ConfigMap, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), ConfigMapTimeout)
+	defer cancel()
+	return c.cs.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+}
+
+// ConfigHashPatch annotates the pod with annotationKey set to a hash of the data in the ConfigMap cmName from the
+// pod's namespace. Templating the annotation into a workload's pod template means a changed ConfigMap changes the
+// template and rolls the workload. Missing ConfigMaps are skipped with a warning.
+func ConfigHashPatch(cmName, annotationKey string) RequestPatchable {
+	return func(req *v1.AdmissionRequest, pod *corev1.Pod) ([]operation, error) {
+		if ConfigMaps == nil {
+			return nil, nil
+		}
+		var cm *corev1.ConfigMap
+		err := lookup(func() error {
+			var err error
+			cm, err = ConfigMaps.Get(req.Namespace, cmName)
+			return err
+		})
+		if err != nil {
+			LevelWarn.Printf("status=skipped namespace=%s configmap=%s err='configmap lookup: %v'", req.Namespace, cmName, err)
+			return nil, nil
+		}
+		return mapEntriesOps(annotationsPath, pod.ObjectMeta.Annotations, map[string]string{annotationKey: configHash(cm)}), nil
+	}
+}
+
+// configHash is a hex sha256 of the ConfigMap's data and binary data, keys are sorted so it's stable across
+// map iteration orders.
+func configHash(cm *corev1.ConfigMap) string {
+	h := sha256.New()
+	write := func(kind, key string, value []byte) {
+		h.Write([]byte(kind))
+		h.Write([]byte(key))
+		h.Write([]byte{0})
+		h.Write(value)
+		h.Write([]byte{0})
+	}
+	keys := make([]string, 0, len(cm.Data))
+	for k := range cm.Data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		write("data:", k, []byte(cm.Data[k]))
+	}
+	keys = keys[:0]
+	for k := range cm.BinaryData {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		write("binaryData:", k, cm.BinaryData[k])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
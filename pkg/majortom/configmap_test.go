@@ -0,0 +1,63 @@
+package majortom
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func withConfigMaps(t *testing.T, configMaps ...*corev1.ConfigMap) {
+	var objects []runtime.Object
+	for _, cm := range configMaps {
+		objects = append(objects, cm)
+	}
+	ConfigMaps = clientConfigMaps{fake.NewSimpleClientset(objects...)}
+	t.Cleanup(func() { ConfigMaps = nil })
+}
+
+func Test_ConfigHashPatch(t *testing.T) {
+	withConfigMaps(t, &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "app-config", Namespace: "tide"},
+		Data:       map[string]string{"b": "2", "a": "1"},
+	})
+	want := configHash(&corev1.ConfigMap{Data: map[string]string{"a": "1", "b": "2"}})
+	cases := map[string]struct {
+		namespace string
+		hash      string
+	}{
+		"present": {"tide", want},
+		"missing": {"default", ""},
+	}
+
+	for n, tc := range cases {
+		tc := tc
+		t.Run(n, func(t *testing.T) {
+			pod := &corev1.Pod{}
+			req := &v1.AdmissionRequest{Namespace: tc.namespace}
+			ops, err := ConfigHashPatch("app-config", "majortom.io/config-hash")(req, pod)
+			if err != nil {
+				t.Fatalf("err=%v, want nil", err)
+			}
+			patched := applyPatch(t, pod, ops)
+			if patched.Annotations["majortom.io/config-hash"] != tc.hash {
+				t.Errorf("annotations[majortom.io/config-hash]=%v, want %v", patched.Annotations["majortom.io/config-hash"], tc.hash)
+			}
+		})
+	}
+}
+
+func Test_configHash_changes_with_data(t *testing.T) {
+	a := configHash(&corev1.ConfigMap{Data: map[string]string{"log-level": "info"}})
+	b := configHash(&corev1.ConfigMap{Data: map[string]string{"log-level": "debug"}})
+	if a == b {
+		t.Errorf("configHash=%v for both, want different hashes", a)
+	}
+	c := configHash(&corev1.ConfigMap{BinaryData: map[string][]byte{"log-level": []byte("info")}})
+	if a == c {
+		t.Errorf("configHash=%v for data and binaryData, want different hashes", a)
+	}
+}
@@ -0,0 +1,198 @@
+package majortom
+
+import (
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func containerPath(i int, field string) string {
+	return fmt.Sprintf("/spec/containers/%d/%s", i, field)
+}
+
+// PreStopPatch adds handler as the preStop hook of every container without one. The handler type is named
+// LifecycleHandler in newer API versions.
+func PreStopPatch(handler corev1.Handler) PodPatchable {
+	return func(pod *corev1.Pod) ([]operation, error) {
+		var ops []operation
+		for i, container := range pod.Spec.Containers {
+			if container.Lifecycle == nil {
+				ops = append(ops, addOp(containerPath(i, "lifecycle"), map[string]interface{}{"preStop": handler}))
+				continue
+			}
+			if container.Lifecycle.PreStop == nil {
+				ops = append(ops, addOp(containerPath(i, "lifecycle/preStop"), handler))
+			}
+		}
+		return ops, nil
+	}
+}
+
+// ProbeDefaultsPatch adds startup as the startupProbe of containers that expose a port and don't define one.
+func ProbeDefaultsPatch(startup corev1.Probe) PodPatchable {
+	return func(pod *corev1.Pod) ([]operation, error) {
+		var ops []operation
+		for i, container := range pod.Spec.Containers {
+			if len(container.Ports) == 0 || container.StartupProbe != nil {
+				continue
+			}
+			ops = append(ops, addOp(containerPath(i, "startupProbe"), startup))
+		}
+		return ops, nil
+	}
+}
+
+// LivenessDelayPatch raises the liveness probe initialDelaySeconds of containers without a startupProbe to seconds so
+// slow starting apps aren't killed before they've booted. A startupProbe already holds off the liveness probe.
+func LivenessDelayPatch(seconds int32) PodPatchable {
+	return func(pod *corev1.Pod) ([]operation, error) {
+		var ops []operation
+		for i, container := range pod.Spec.Containers {
+			probe := container.LivenessProbe
+			if probe == nil || container.StartupProbe != nil || probe.InitialDelaySeconds >= seconds {
+				continue
+			}
+			path := containerPath(i, "livenessProbe/initialDelaySeconds")
+			// a zero delay is omitted from the pod so there's nothing to replace.
+			if probe.InitialDelaySeconds == 0 {
+				ops = append(ops, addOp(path, seconds))
+				continue
+			}
+			ops = append(ops, replaceOp(path, seconds))
+		}
+		return ops, nil
+	}
+}
+
+// ImagePullPolicyPatch sets the imagePullPolicy of containers without one, Always for :latest or untagged images and
+// IfNotPresent for pinned tags and digests.
+func ImagePullPolicyPatch() PodPatchable {
+	return func(pod *corev1.Pod) ([]operation, error) {
+		var ops []operation
+		for i, container := range pod.Spec.Containers {
+			if container.ImagePullPolicy != "" {
+				continue
+			}
+			policy := corev1.PullIfNotPresent
+			if isLatest(container.Image) {
+				policy = corev1.PullAlways
+			}
+			ops = append(ops, addOp(containerPath(i, "imagePullPolicy"), policy))
+		}
+		return ops, nil
+	}
+}
+
+// isLatest reports whether image resolves to the latest tag, either explicitly or by omitting a tag and digest.
+func isLatest(image string) bool {
+	_, _, tag, digest := parseImageRef(image)
+	return digest == "" && (tag == "" || tag == "latest")
+}
+
+// ClampResourcesPatch lowers container limits above maxCPU and maxMem to them, an empty max leaves the resource
+// uncapped. See ClampResourceListPatch, which routes use to cap a resource list parsed from the config.
+func ClampResourcesPatch(maxCPU, maxMem string) PodPatchable {
+	max := corev1.ResourceList{}
+	for _, r := range []struct {
+		name corev1.ResourceName
+		v    string
+	}{{corev1.ResourceCPU, maxCPU}, {corev1.ResourceMemory, maxMem}} {
+		if r.v == "" {
+			continue
+		}
+		q, err := resource.ParseQuantity(r.v)
+		if err != nil {
+			err = fmt.Errorf("max %s %q: %v", r.name, r.v, err)
+			return func(pod *corev1.Pod) ([]operation, error) {
+				return nil, err
+			}
+		}
+		max[r.name] = q
+	}
+	return ClampResourceListPatch(max)
+}
+
+// ClampResourceListPatch lowers container and init container limits above max to the max, resources missing from
+// max are left uncapped. Init containers are clamped as the scheduler sizes the pod by the larger of their limits and
+// the containers' sum. Requests above the resulting limit are lowered to it as the apiserver rejects requests
+// exceeding limits. Containers without a limit are left as-is.
+func ClampResourceListPatch(max corev1.ResourceList) PodPatchable {
+	names := make([]string, 0, len(max))
+	for name := range max {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+	return func(pod *corev1.Pod) ([]operation, error) {
+		var ops []operation
+		for _, n := range names {
+			name := corev1.ResourceName(n)
+			ops = append(ops, clampOps("/spec/initContainers", pod.Spec.InitContainers, name, max[name])...)
+			ops = append(ops, clampOps("/spec/containers", pod.Spec.Containers, name, max[name])...)
+		}
+		return ops, nil
+	}
+}
+
+// clampOps lowers the name limit of each of the containers at base above ceiling to it, and their requests above the
+// resulting limit.
+func clampOps(base string, containers []corev1.Container, name corev1.ResourceName, ceiling resource.Quantity) []operation {
+	var ops []operation
+	for i, container := range containers {
+		limit, ok := container.Resources.Limits[name]
+		if !ok {
+			continue
+		}
+		if limit.Cmp(ceiling) > 0 {
+			ops = append(ops, replaceOp(fmt.Sprintf("%s/%d/resources/limits/%s", base, i, name), ceiling.String()))
+			limit = ceiling
+		}
+		request, ok := container.Resources.Requests[name]
+		if ok && request.Cmp(limit) > 0 {
+			ops = append(ops, replaceOp(fmt.Sprintf("%s/%d/resources/requests/%s", base, i, name), limit.String()))
+		}
+	}
+	return ops
+}
+
+// ResourceDefaultsPatch sets the requests and limits in defaults on containers that don't specify them, resources
+// a container already specifies are left as-is.
+func ResourceDefaultsPatch(defaults corev1.ResourceRequirements) PodPatchable {
+	return func(pod *corev1.Pod) ([]operation, error) {
+		var ops []operation
+		for i, container := range pod.Spec.Containers {
+			ops = append(ops, resourceListOps(containerPath(i, "resources/requests"), container.Resources.Requests, defaults.Requests)...)
+			ops = append(ops, resourceListOps(containerPath(i, "resources/limits"), container.Resources.Limits, defaults.Limits)...)
+		}
+		return ops, nil
+	}
+}
+
+// resourceListOps adds the resources in defaults missing from existing to the list at base.
+func resourceListOps(base string, existing, defaults corev1.ResourceList) []operation {
+	missing := corev1.ResourceList{}
+	for name, q := range defaults {
+		_, ok := existing[name]
+		if !ok {
+			missing[name] = q
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	if existing == nil {
+		return []operation{addOp(base, missing)}
+	}
+	names := make([]string, 0, len(missing))
+	for name := range missing {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+	var ops []operation
+	for _, name := range names {
+		q := missing[corev1.ResourceName(name)]
+		ops = append(ops, addOp(base+"/"+escapePointer(name), q.String()))
+	}
+	return ops
+}
@@ -0,0 +1,269 @@
+package majortom
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+var sleep5 = corev1.Handler{Exec: &corev1.ExecAction{Command: []string{"sleep", "5"}}}
+
+func Test_PreStopPatch(t *testing.T) {
+	existing := corev1.Handler{Exec: &corev1.ExecAction{Command: []string{"/drain"}}}
+	postStart := corev1.Handler{Exec: &corev1.ExecAction{Command: []string{"/warm"}}}
+	pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{
+		{Name: "no-lifecycle", Image: "nginx:latest"},
+		{Name: "post-start", Image: "nginx:latest", Lifecycle: &corev1.Lifecycle{PostStart: &postStart}},
+		{Name: "pre-stop", Image: "nginx:latest", Lifecycle: &corev1.Lifecycle{PreStop: &existing}},
+	}}}
+	ops, err := PreStopPatch(sleep5)(pod)
+	if err != nil {
+		t.Fatalf("err=%v, want nil", err)
+	}
+	if len(ops) != 2 {
+		t.Errorf("len(ops)=%v, want 2", len(ops))
+	}
+
+	patched := applyPatch(t, pod, ops)
+	expected := []*corev1.Handler{&sleep5, &sleep5, &existing}
+	for i, c := range patched.Spec.Containers {
+		if !cmp.Equal(c.Lifecycle.PreStop, expected[i]) {
+			t.Errorf("containers[%d] preStop mismatch (+want -got)\n%s", i, cmp.Diff(c.Lifecycle.PreStop, expected[i]))
+		}
+	}
+	if !cmp.Equal(patched.Spec.Containers[1].Lifecycle.PostStart, &postStart) {
+		t.Error("containers[1].lifecycle.postStart was modified")
+	}
+}
+
+func Test_ProbeDefaultsPatch(t *testing.T) {
+	startup := corev1.Probe{
+		Handler:          corev1.Handler{TCPSocket: &corev1.TCPSocketAction{Port: intstr.FromInt(8080)}},
+		FailureThreshold: 30,
+		PeriodSeconds:    10,
+	}
+	existing := corev1.Probe{Handler: corev1.Handler{Exec: &corev1.ExecAction{Command: []string{"/ready"}}}}
+	ports := []corev1.ContainerPort{{ContainerPort: 8080}}
+	pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{
+		{Name: "web", Image: "nginx:latest", Ports: ports},
+		{Name: "probed", Image: "nginx:latest", Ports: ports, StartupProbe: &existing},
+		{Name: "worker", Image: "worker:latest"},
+	}}}
+	ops, err := ProbeDefaultsPatch(startup)(pod)
+	if err != nil {
+		t.Fatalf("err=%v, want nil", err)
+	}
+	if len(ops) != 1 {
+		t.Errorf("len(ops)=%v, want 1", len(ops))
+	}
+
+	patched := applyPatch(t, pod, ops)
+	expected := []*corev1.Probe{&startup, &existing, nil}
+	for i, c := range patched.Spec.Containers {
+		if !cmp.Equal(c.StartupProbe, expected[i]) {
+			t.Errorf("containers[%d] startupProbe mismatch (+want -got)\n%s", i, cmp.Diff(c.StartupProbe, expected[i]))
+		}
+	}
+}
+
+func Test_LivenessDelayPatch(t *testing.T) {
+	probe := func(delay int32) *corev1.Probe {
+		return &corev1.Probe{
+			Handler:             corev1.Handler{HTTPGet: &corev1.HTTPGetAction{Path: "/livez", Port: intstr.FromInt(8080)}},
+			InitialDelaySeconds: delay,
+		}
+	}
+	pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{
+		{Name: "below", Image: "nginx:1.19.2", LivenessProbe: probe(5)},
+		{Name: "unset", Image: "nginx:1.19.2", LivenessProbe: probe(0)},
+		{Name: "above", Image: "nginx:1.19.2", LivenessProbe: probe(60)},
+		{Name: "startup", Image: "nginx:1.19.2", LivenessProbe: probe(5), StartupProbe: probe(0)},
+		{Name: "unprobed", Image: "nginx:1.19.2"},
+	}}}
+	ops, err := LivenessDelayPatch(30)(pod)
+	if err != nil {
+		t.Fatalf("err=%v, want nil", err)
+	}
+	if len(ops) != 2 {
+		t.Errorf("len(ops)=%v, want 2", len(ops))
+	}
+
+	patched := applyPatch(t, pod, ops)
+	expected := []int32{30, 30, 60, 5}
+	for i, want := range expected {
+		actual := patched.Spec.Containers[i].LivenessProbe.InitialDelaySeconds
+		if actual != want {
+			t.Errorf("containers[%d] initialDelaySeconds=%v, want %v", i, actual, want)
+		}
+	}
+	if patched.Spec.Containers[4].LivenessProbe != nil {
+		t.Errorf("containers[4] livenessProbe=%v, want nil", patched.Spec.Containers[4].LivenessProbe)
+	}
+}
+
+func Test_ImagePullPolicyPatch(t *testing.T) {
+	cases := map[string]struct {
+		image  string
+		policy corev1.PullPolicy
+		want   corev1.PullPolicy
+	}{
+		"pinned tag":         {"nginx:1.19.2", "", corev1.PullIfNotPresent},
+		"latest tag":         {"nginx:latest", "", corev1.PullAlways},
+		"untagged":           {"nginx", "", corev1.PullAlways},
+		"registry port":      {"localhost:5000/nginx", "", corev1.PullAlways},
+		"registry port tag":  {"localhost:5000/nginx:1.19.2", "", corev1.PullIfNotPresent},
+		"digest":             {"nginx@sha256:4949aa7259aa6f827450207db5ad94cabaa9248277c6d736d5e1975d200c7e43", "", corev1.PullIfNotPresent},
+		"already set policy": {"nginx:latest", corev1.PullNever, corev1.PullNever},
+	}
+
+	for n, tc := range cases {
+		tc := tc
+		t.Run(n, func(t *testing.T) {
+			pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Image: tc.image, ImagePullPolicy: tc.policy}}}}
+			ops, err := ImagePullPolicyPatch()(pod)
+			if err != nil {
+				t.Fatalf("err=%v, want nil", err)
+			}
+			patched := applyPatch(t, pod, ops)
+			actual := patched.Spec.Containers[0].ImagePullPolicy
+			if actual != tc.want {
+				t.Errorf("imagePullPolicy=%v, want %v", actual, tc.want)
+			}
+		})
+	}
+}
+
+func Test_ClampResourcesPatch(t *testing.T) {
+	list := func(cpu, mem string) corev1.ResourceList {
+		return corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(cpu), corev1.ResourceMemory: resource.MustParse(mem)}
+	}
+	cases := map[string]struct {
+		resources corev1.ResourceRequirements
+		ops       int
+		limits    corev1.ResourceList
+		requests  corev1.ResourceList
+	}{
+		"over the cap":  {corev1.ResourceRequirements{Limits: list("8", "64Gi"), Requests: list("500m", "1Gi")}, 2, list("2", "4Gi"), list("500m", "1Gi")},
+		"requests over": {corev1.ResourceRequirements{Limits: list("8", "64Gi"), Requests: list("4", "8Gi")}, 4, list("2", "4Gi"), list("2", "4Gi")},
+		"under the cap": {corev1.ResourceRequirements{Limits: list("1", "2Gi"), Requests: list("500m", "1Gi")}, 0, list("1", "2Gi"), list("500m", "1Gi")},
+		"no resources":  {corev1.ResourceRequirements{}, 0, nil, nil},
+	}
+
+	for n, tc := range cases {
+		tc := tc
+		t.Run(n, func(t *testing.T) {
+			pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Resources: tc.resources}}}}
+			ops, err := ClampResourcesPatch("2", "4Gi")(pod)
+			if err != nil {
+				t.Fatalf("err=%v, want nil", err)
+			}
+			if len(ops) != tc.ops {
+				t.Errorf("len(ops)=%v, want %v", len(ops), tc.ops)
+			}
+			patched := applyPatch(t, pod, ops)
+			actual := patched.Spec.Containers[0].Resources
+			for name, want := range tc.limits {
+				got := actual.Limits[name]
+				if got.Cmp(want) != 0 {
+					t.Errorf("limits[%s]=%v, want %v", name, got.String(), want.String())
+				}
+			}
+			for name, want := range tc.requests {
+				got := actual.Requests[name]
+				if got.Cmp(want) != 0 {
+					t.Errorf("requests[%s]=%v, want %v", name, got.String(), want.String())
+				}
+			}
+			if tc.limits == nil && len(actual.Limits) > 0 {
+				t.Errorf("limits=%v, want none", actual.Limits)
+			}
+		})
+	}
+}
+
+func Test_ClampResourceListPatch_init_containers(t *testing.T) {
+	list := func(cpu, mem string) corev1.ResourceList {
+		return corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(cpu), corev1.ResourceMemory: resource.MustParse(mem)}
+	}
+	cases := map[string]struct {
+		resources corev1.ResourceRequirements
+		paths     []string
+	}{
+		"over the cap":  {corev1.ResourceRequirements{Limits: list("8", "64Gi"), Requests: list("500m", "1Gi")}, []string{"/spec/initContainers/0/resources/limits/cpu", "/spec/initContainers/0/resources/limits/memory"}},
+		"requests over": {corev1.ResourceRequirements{Limits: list("8", "2Gi"), Requests: list("4", "1Gi")}, []string{"/spec/initContainers/0/resources/limits/cpu", "/spec/initContainers/0/resources/requests/cpu"}},
+		"under the cap": {corev1.ResourceRequirements{Limits: list("1", "2Gi"), Requests: list("500m", "1Gi")}, nil},
+		"no resources":  {corev1.ResourceRequirements{}, nil},
+	}
+
+	for n, tc := range cases {
+		tc := tc
+		t.Run(n, func(t *testing.T) {
+			pod := &corev1.Pod{Spec: corev1.PodSpec{
+				InitContainers: []corev1.Container{{Name: "migrate", Resources: tc.resources}},
+				Containers:     []corev1.Container{{Name: "app"}},
+			}}
+			ops, err := ClampResourceListPatch(list("2", "4Gi"))(pod)
+			if err != nil {
+				t.Fatalf("err=%v, want nil", err)
+			}
+			var actual []string
+			for _, op := range ops {
+				actual = append(actual, op.Path)
+			}
+			if !cmp.Equal(actual, tc.paths) {
+				t.Errorf("paths mismatch (+want -got)\n%s", cmp.Diff(actual, tc.paths))
+			}
+			patched := applyPatch(t, pod, ops)
+			limits := patched.Spec.InitContainers[0].Resources.Limits
+			for name, ceiling := range list("2", "4Gi") {
+				got, ok := limits[name]
+				if ok && got.Cmp(ceiling) > 0 {
+					t.Errorf("initContainers[0] limits[%s]=%v, want <= %v", name, got.String(), ceiling.String())
+				}
+			}
+		})
+	}
+}
+
+func Test_ClampResourcesPatch_invalid_quantity(t *testing.T) {
+	_, err := ClampResourcesPatch("two", "4Gi")(&corev1.Pod{})
+	if err == nil || !strings.Contains(err.Error(), `max cpu "two"`) {
+		t.Errorf("err=%v, want max cpu \"two\"", err)
+	}
+}
+
+func Test_ResourceDefaultsPatch(t *testing.T) {
+	defaults := corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m"), corev1.ResourceMemory: resource.MustParse("128Mi")},
+		Limits:   corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("256Mi")},
+	}
+	pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{
+		{Name: "bare"},
+		{Name: "partial", Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")}}},
+	}}}
+	ops, err := ResourceDefaultsPatch(defaults)(pod)
+	if err != nil {
+		t.Fatalf("err=%v, want nil", err)
+	}
+	patched := applyPatch(t, pod, ops)
+	want := []map[corev1.ResourceName]string{
+		{corev1.ResourceCPU: "100m", corev1.ResourceMemory: "128Mi"},
+		{corev1.ResourceCPU: "1", corev1.ResourceMemory: "128Mi"},
+	}
+	for i, c := range patched.Spec.Containers {
+		for name, q := range want[i] {
+			actual := c.Resources.Requests[name]
+			if actual.String() != q {
+				t.Errorf("containers[%d].requests[%s]=%v, want %v", i, name, actual.String(), q)
+			}
+		}
+		limit := c.Resources.Limits[corev1.ResourceMemory]
+		if limit.String() != "256Mi" {
+			t.Errorf("containers[%d].limits[memory]=%v, want 256Mi", i, limit.String())
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package majortom
+
+import (
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// DeprecatedField is a pod field that still works but has a replacement users should migrate to.
+type DeprecatedField struct {
+	Field       string
+	Replacement string
+	// Used reports whether the pod sets the field.
+	Used func(*corev1.Pod) bool
+}
+
+// DeprecatedAnnotation is a DeprecatedField for pods annotated with key, a key ending in / matches it as a prefix.
+func DeprecatedAnnotation(key, replacement string) DeprecatedField {
+	return DeprecatedField{
+		Field:       "metadata.annotations[" + key + "]",
+		Replacement: replacement,
+		Used: func(pod *corev1.Pod) bool {
+			for k := range pod.ObjectMeta.Annotations {
+				if k == key || (strings.HasSuffix(key, "/") && strings.HasPrefix(k, key)) {
+					return true
+				}
+			}
+			return false
+		},
+	}
+}
+
+// DeprecatedFields are the deprecations WarnDeprecated checks by default.
+//
+// spec.serviceAccount can't be detected, the apiserver converts pods with it set to serviceAccountName and populates
+// it from serviceAccountName before webhooks see them so every pod with a service account would match.
+var DeprecatedFields = []DeprecatedField{
+	DeprecatedAnnotation(corev1.SeccompPodAnnotationKey, "spec.securityContext.seccompProfile"),
+	DeprecatedAnnotation(corev1.SeccompContainerAnnotationKeyPrefix, "securityContext.seccompProfile"),
+	DeprecatedAnnotation("scheduler.alpha.kubernetes.io/critical-pod", "spec.priorityClassName"),
+}
+
+// WarnDeprecated fails pods using any of fields, it is intended for Router.HandleValidate in Warn mode so users are
+// told about fields to migrate from without breaking their workloads.
+func WarnDeprecated(fields ...DeprecatedField) PodValidatable {
+	return func(pod *corev1.Pod) error {
+		ve := &ValidationError{Message: "deprecated fields"}
+		for _, f := range fields {
+			if f.Used(pod) {
+				ve.Add(f.Field, "deprecated, use "+f.Replacement)
+			}
+		}
+		return ve.Err()
+	}
+}
@@ -0,0 +1,54 @@
+package majortom
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func Test_WarnDeprecated(t *testing.T) {
+	cases := map[string]struct {
+		annotations map[string]string
+		warning     string
+	}{
+		"pod seccomp":       {map[string]string{corev1.SeccompPodAnnotationKey: "runtime/default"}, "deprecated fields: metadata.annotations[seccomp.security.alpha.kubernetes.io/pod]: deprecated, use spec.securityContext.seccompProfile"},
+		"container seccomp": {map[string]string{corev1.SeccompContainerAnnotationKeyPrefix + "app": "runtime/default"}, "deprecated fields: metadata.annotations[container.seccomp.security.alpha.kubernetes.io/]: deprecated, use securityContext.seccompProfile"},
+		"current fields":    {map[string]string{"app": "tide"}, ""},
+	}
+
+	for n, tc := range cases {
+		tc := tc
+		t.Run(n, func(t *testing.T) {
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: tc.annotations}}
+			err := WarnDeprecated(DeprecatedFields...)(pod)
+			var actual string
+			if err != nil {
+				actual = err.Error()
+			}
+			if actual != tc.warning {
+				t.Errorf("err=%q, want %q", actual, tc.warning)
+			}
+		})
+	}
+}
+
+func Test_WarnDeprecated_allows_with_warning(t *testing.T) {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "tide", Annotations: map[string]string{corev1.SeccompPodAnnotationKey: "runtime/default"}}}
+	raw, _ := json.Marshal(pod)
+	r := post(&v1.AdmissionReview{Request: &v1.AdmissionRequest{UID: "abc123", Namespace: "default", Resource: resourcePods, Object: runtime.RawExtension{Raw: raw}}})
+	w := httptest.NewRecorder()
+	podValidate(w, r, WarnDeprecated(DeprecatedFields...), Warn)
+	resp := decodeResponse(t, w)
+	if !resp.Allowed {
+		t.Error("resp.Allowed=false, want true")
+	}
+	if len(resp.Warnings) != 1 || !strings.Contains(resp.Warnings[0], corev1.SeccompPodAnnotationKey) {
+		t.Errorf("resp.Warnings=%v, want warning naming %s", resp.Warnings, corev1.SeccompPodAnnotationKey)
+	}
+}
@@ -0,0 +1,120 @@
+package majortom
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	v1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// EphemeralContainersSubresource is the pods subresource kubectl debug updates to add an ephemeral container. The
+// webhook configuration must include an UPDATE rule for pods/ephemeralcontainers for these requests to be reviewed.
+const EphemeralContainersSubresource = "ephemeralcontainers"
+
+// EphemeralContainersKind is the kind of the object apiservers before 1.23 send for the ephemeralcontainers
+// subresource in place of the pod.
+const EphemeralContainersKind = "EphemeralContainers"
+
+// EphemeralVarsPatch injects env vars referencing the downward API fieldPaths in vars into the ephemeral containers
+// added by the request so debug containers see the same context as the pod's containers. The apiserver rejects
+// changes to existing ephemeral containers so those in the request's old object are left as-is, as are vars a new
+// container already defines.
+func EphemeralVarsPatch(vars map[string]string) RequestPatchable {
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return func(req *v1.AdmissionRequest, pod *corev1.Pod) ([]operation, error) {
+		for _, name := range names {
+			err := checkFieldPath(vars[name])
+			if err != nil {
+				return nil, err
+			}
+		}
+		existing, err := existingEphemeral(req)
+		if err != nil {
+			return nil, err
+		}
+		var ops []operation
+		for i, c := range pod.Spec.EphemeralContainers {
+			if existing[c.Name] {
+				continue
+			}
+			defined := make(map[string]bool, len(c.Env))
+			for _, env := range c.Env {
+				defined[env.Name] = true
+			}
+			var env []corev1.EnvVar
+			for _, name := range names {
+				if !defined[name] {
+					env = append(env, fieldRefEnv(name, vars[name]))
+				}
+			}
+			if len(env) == 0 {
+				continue
+			}
+			if len(c.Env) == 0 {
+				ops = append(ops, addOp(fmt.Sprintf("/spec/ephemeralContainers/%d/env", i), env))
+				continue
+			}
+			for j := range env {
+				ops = append(ops, addOp(EnvAppendStyle.path(fmt.Sprintf("/spec/ephemeralContainers/%d/env", i), len(c.Env)+j), env[j]))
+			}
+		}
+		return ops, nil
+	}
+}
+
+// ephemeralContainersOps rewrites the pod paths of ops to the paths of an EphemeralContainers object, which has
+// ephemeralContainers at its root rather than in a spec.
+func ephemeralContainersOps(ops []operation) []operation {
+	rewritten := make([]operation, 0, len(ops))
+	for _, op := range ops {
+		if strings.HasPrefix(op.Path, "/spec/ephemeralContainers") {
+			op.Path = strings.TrimPrefix(op.Path, "/spec")
+		}
+		rewritten = append(rewritten, op)
+	}
+	return rewritten
+}
+
+// existingEphemeral returns the names of the ephemeral containers in the request's old object, empty when there's no
+// old object.
+func existingEphemeral(req *v1.AdmissionRequest) (map[string]bool, error) {
+	existing := map[string]bool{}
+	if len(req.OldObject.Raw) == 0 {
+		return existing, nil
+	}
+	old, err := decodePod(req.OldObject.Raw)
+	if err != nil {
+		return nil, fmt.Errorf("old pod: %w", err)
+	}
+	for _, c := range old.Spec.EphemeralContainers {
+		existing[c.Name] = true
+	}
+	return existing, nil
+}
+
+func fieldRefEnv(name, fieldPath string) corev1.EnvVar {
+	return corev1.EnvVar{Name: name, ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: fieldPath}}}
+}
+
+func bindEphemeral(rp RequestPatchable, policy func() FailPolicy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		review, ok := readRequest(w, r)
+		if !ok {
+			return
+		}
+
+		resp, err := reviewSubresource(route(r), review.Request, EphemeralContainersSubresource, rp.patchable(review.Request), policy())
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeReview(w, r, resp)
+	}
+}
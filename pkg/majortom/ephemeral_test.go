@@ -0,0 +1,184 @@
+package majortom
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/google/go-cmp/cmp"
+	v1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func debugPod() *corev1.Pod {
+	return &corev1.Pod{Spec: corev1.PodSpec{
+		Containers: []corev1.Container{{Name: "app", Image: "nginx:latest"}},
+		EphemeralContainers: []corev1.EphemeralContainer{
+			{EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: "debugger-old", Image: "busybox", Env: []corev1.EnvVar{fieldRefEnv("NODEIP", "status.hostIP")}}},
+			{EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: "debugger-new", Image: "busybox", Env: []corev1.EnvVar{{Name: "NODEIP", Value: "localhost"}}}},
+			{EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: "debugger-bare", Image: "busybox"}},
+		},
+	}}
+}
+
+// debugRequest is the update adding debugger-new and debugger-bare to a pod previously debugged with debugger-old.
+func debugRequest() *v1.AdmissionRequest {
+	pod := debugPod()
+	old := debugPod()
+	old.Spec.EphemeralContainers = old.Spec.EphemeralContainers[:1]
+	raw, _ := json.Marshal(pod)
+	oldRaw, _ := json.Marshal(old)
+	return &v1.AdmissionRequest{
+		UID:         "abc123",
+		Namespace:   "default",
+		Operation:   v1.Update,
+		Resource:    resourcePods,
+		SubResource: EphemeralContainersSubresource,
+		Object:      runtime.RawExtension{Raw: raw},
+		OldObject:   runtime.RawExtension{Raw: oldRaw},
+	}
+}
+
+func Test_EphemeralVarsPatch(t *testing.T) {
+	pod := debugPod()
+	ops, err := EphemeralVarsPatch(map[string]string{"NODEIP": "status.hostIP", "PODIP": "status.podIP"})(debugRequest(), pod)
+	if err != nil {
+		t.Fatalf("err=%v, want nil", err)
+	}
+	patched := applyPatch(t, pod, ops)
+	expected := [][]string{
+		{"NODEIP"},
+		{"NODEIP", "PODIP"},
+		{"NODEIP", "PODIP"},
+	}
+	for i, c := range patched.Spec.EphemeralContainers {
+		var names []string
+		for _, env := range c.Env {
+			names = append(names, env.Name)
+		}
+		if !cmp.Equal(names, expected[i]) {
+			t.Errorf("ephemeralContainers[%d] env mismatch (+want -got)\n%s", i, cmp.Diff(names, expected[i]))
+		}
+	}
+	for _, op := range ops {
+		if strings.HasPrefix(op.Path, "/spec/ephemeralContainers/0/") {
+			t.Errorf("op=%v, want existing ephemeral container untouched", op)
+		}
+	}
+	if patched.Spec.EphemeralContainers[1].Env[0].Value != "localhost" {
+		t.Errorf("ephemeralContainers[1].env[0]=%#v, want existing value kept", patched.Spec.EphemeralContainers[1].Env[0])
+	}
+	if len(patched.Spec.Containers[0].Env) != 0 {
+		t.Errorf("containers[0].env=%v, want untouched", patched.Spec.Containers[0].Env)
+	}
+}
+
+func Test_EphemeralVarsPatch_all_existing(t *testing.T) {
+	req := debugRequest()
+	req.OldObject = req.Object
+	ops, err := EphemeralVarsPatch(map[string]string{"PODIP": "status.podIP"})(req, debugPod())
+	if err != nil {
+		t.Fatalf("err=%v, want nil", err)
+	}
+	if len(ops) != 0 {
+		t.Errorf("ops=%v, want none for a pod without new ephemeral containers", ops)
+	}
+}
+
+func Test_HandleEphemeral(t *testing.T) {
+	cases := map[string]struct {
+		subresource string
+		patch       bool
+	}{
+		"ephemeralcontainers subresource": {EphemeralContainersSubresource, true},
+		"pod":                             {"", false},
+		"status subresource":              {"status", false},
+	}
+
+	for n, tc := range cases {
+		tc := tc
+		t.Run(n, func(t *testing.T) {
+			rt := NewRouter()
+			rt.HandleEphemeral("/ephemeral", EphemeralVarsPatch(map[string]string{"PODIP": "status.podIP"}))
+			req := debugRequest()
+			req.SubResource = tc.subresource
+			r := post(&v1.AdmissionReview{Request: req})
+			r.URL.Path = "/ephemeral"
+			w := httptest.NewRecorder()
+			rt.mux.ServeHTTP(w, r)
+			if w.Code != http.StatusOK {
+				t.Fatalf("w.Code=%v, want StatusOK", w.Code)
+			}
+			resp := decodeResponse(t, w)
+			if !resp.Allowed {
+				t.Error("resp.Allowed=false, want true")
+			}
+			actual := resp.Patch != nil
+			if actual != tc.patch {
+				t.Errorf("patched=%v, want %v", actual, tc.patch)
+			}
+			var ops []operation
+			_ = json.Unmarshal(resp.Patch, &ops)
+			for _, op := range ops {
+				if strings.HasPrefix(op.Path, "/metadata") {
+					t.Errorf("op=%v, want no metadata changes on subresource", op)
+				}
+			}
+		})
+	}
+}
+
+// legacyDebugRequest is debugRequest as apiservers before 1.23 send it, with EphemeralContainers objects.
+func legacyDebugRequest() *v1.AdmissionRequest {
+	req := debugRequest()
+	objects := []*runtime.RawExtension{&req.Object, &req.OldObject}
+	for _, obj := range objects {
+		var pod corev1.Pod
+		_ = json.Unmarshal(obj.Raw, &pod)
+		ec := corev1.EphemeralContainers{
+			TypeMeta:            metav1.TypeMeta{APIVersion: "v1", Kind: EphemeralContainersKind},
+			ObjectMeta:          metav1.ObjectMeta{Name: "tide", Namespace: "default"},
+			EphemeralContainers: pod.Spec.EphemeralContainers,
+		}
+		obj.Raw, _ = json.Marshal(&ec)
+	}
+	req.Kind = metav1.GroupVersionKind{Version: "v1", Kind: EphemeralContainersKind}
+	return req
+}
+
+func Test_EphemeralVarsPatch_legacy_kind(t *testing.T) {
+	req := legacyDebugRequest()
+	resp, err := reviewSubresource("/ephemeral", req, EphemeralContainersSubresource, EphemeralVarsPatch(map[string]string{"PODIP": "status.podIP"}).patchable(req), DefaultFailPolicy)
+	if err != nil {
+		t.Fatalf("err=%v, want nil", err)
+	}
+	patch, err := jsonpatch.DecodePatch(resp.Patch)
+	if err != nil {
+		t.Fatalf("DecodePatch err=%v, want nil", err)
+	}
+	doc, err := patch.Apply(req.Object.Raw)
+	if err != nil {
+		t.Fatalf("Apply err=%v, want nil", err)
+	}
+	var patched corev1.EphemeralContainers
+	_ = json.Unmarshal(doc, &patched)
+	expected := [][]string{
+		{"NODEIP"},
+		{"NODEIP", "PODIP"},
+		{"PODIP"},
+	}
+	for i, c := range patched.EphemeralContainers {
+		var names []string
+		for _, env := range c.Env {
+			names = append(names, env.Name)
+		}
+		if !cmp.Equal(names, expected[i]) {
+			t.Errorf("ephemeralContainers[%d] env mismatch (+want -got)\n%s", i, cmp.Diff(names, expected[i]))
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package majortom
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// FailPolicy determines the response when a patchable can't produce a patch.
+type FailPolicy int
+
+const (
+	// FailWebhook responds with a HTTP error leaving the decision to the webhook configuration's failurePolicy.
+	FailWebhook FailPolicy = iota
+	// FailOpen allows the pod without modification.
+	FailOpen
+	// FailClosed denies the pod.
+	FailClosed
+)
+
+var failPolicyNames = []string{"webhook", "open", "closed"}
+
+// DefaultFailPolicy is applied when a patchable fails.
+var DefaultFailPolicy = FailWebhook
+
+func (p FailPolicy) String() string {
+	if p < FailWebhook || p > FailClosed {
+		return fmt.Sprintf("failpolicy(%d)", int(p))
+	}
+	return failPolicyNames[p]
+}
+
+// Set parses the policy name s for use as a flag.Value.
+func (p *FailPolicy) Set(s string) error {
+	for i, name := range failPolicyNames {
+		if strings.EqualFold(s, name) {
+			*p = FailPolicy(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown fail policy %q, want one of %s", s, strings.Join(failPolicyNames, ", "))
+}
+
+// UnmarshalJSON parses the policy name for use in config.
+func (p *FailPolicy) UnmarshalJSON(b []byte) error {
+	var s string
+	err := json.Unmarshal(b, &s)
+	if err != nil {
+		return err
+	}
+	return p.Set(s)
+}
+
+// policyError carries the fail policy of the patchable that failed with err.
+type policyError struct {
+	policy FailPolicy
+	err    error
+}
+
+func (e *policyError) Error() string {
+	return e.err.Error()
+}
+
+func (e *policyError) Unwrap() error {
+	return e.err
+}
+
+// WithFailPolicy applies policy rather than the DefaultFailPolicy when p fails, e.g. closed for a security sidecar
+// and open for a nice to have label.
+func WithFailPolicy(policy FailPolicy, p PodPatchable) PodPatchable {
+	return func(pod *corev1.Pod) ([]operation, error) {
+		ops, err := p(pod)
+		if err != nil {
+			return nil, &policyError{policy, err}
+		}
+		return ops, nil
+	}
+}
+
+// failPolicy returns the policy for err, the innermost WithFailPolicy wins otherwise the route's policy.
+func failPolicy(err error, policy FailPolicy) FailPolicy {
+	// errors.As stops at the outermost policyError so the chain is walked to the end.
+	for ; err != nil; err = errors.Unwrap(err) {
+		pe, ok := err.(*policyError)
+		if ok {
+			policy = pe.policy
+		}
+	}
+	return policy
+}
+
+// fail returns the response to req according to policy after a patchable failed with err.
+func (p FailPolicy) fail(path string, req *v1.AdmissionRequest, code int, err error) (*v1.AdmissionResponse, error) {
+	switch p {
+	case FailOpen:
+		LevelWarn.Printf("status=failed-open path=%s err='%v'", path, err)
+		return allow(req), nil
+	case FailClosed:
+		LevelInfo.Printf("status=failed-closed path=%s err='%v'", path, err)
+		return &v1.AdmissionResponse{
+			UID:     req.UID,
+			Allowed: false,
+			Result: &metav1.Status{
+				Status:  metav1.StatusFailure,
+				Message: err.Error(),
+				Reason:  metav1.StatusReasonForbidden,
+				Code:    int32(code),
+			},
+		}, nil
+	default:
+		LevelInfo.Printf("status=failed path=%s err='%v'", path, err)
+		return nil, &ReviewError{code, err.Error()}
+	}
+}
@@ -0,0 +1,245 @@
+package majortom
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func Test_FailPolicy_Set(t *testing.T) {
+	var p FailPolicy
+	err := p.Set("Closed")
+	if err != nil {
+		t.Errorf("err=%v, want nil", err)
+	}
+	if p != FailClosed {
+		t.Errorf("p=%v, want closed", p)
+	}
+	err = p.Set("ajar")
+	if err == nil {
+		t.Error("err=nil, want error")
+	}
+}
+
+func failWith(t *testing.T, policy FailPolicy) *httptest.ResponseRecorder {
+	DefaultFailPolicy = policy
+	t.Cleanup(func() { DefaultFailPolicy = FailWebhook })
+	r := post(&v1.AdmissionReview{Request: &v1.AdmissionRequest{UID: "abc123", Namespace: "default", Resource: resourcePods, Object: podWithOwnerLabel()}})
+	w := httptest.NewRecorder()
+	podPatch(w, r, AddOwner, DefaultFailPolicy)
+	return w
+}
+
+func decodeResponse(t *testing.T, w *httptest.ResponseRecorder) *v1.AdmissionResponse {
+	var review v1.AdmissionReview
+	err := json.NewDecoder(w.Body).Decode(&review)
+	if err != nil {
+		t.Fatalf("Decode err=%v, want nil", err)
+	}
+	return review.Response
+}
+
+func Test_FailPolicy_webhook(t *testing.T) {
+	w := failWith(t, FailWebhook)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("w.Code=%v, want StatusForbidden", w.Code)
+	}
+}
+
+func Test_FailPolicy_open(t *testing.T) {
+	w := failWith(t, FailOpen)
+	resp := decodeResponse(t, w)
+	if !resp.Allowed || resp.Patch != nil {
+		t.Errorf("resp=%#v, want allowed without patch", resp)
+	}
+}
+
+func Test_FailPolicy_closed(t *testing.T) {
+	w := failWith(t, FailClosed)
+	resp := decodeResponse(t, w)
+	if resp.Allowed {
+		t.Error("resp.Allowed=true, want false")
+	}
+	if resp.Result.Message != ErrPodHasOwnerLabel.Error() {
+		t.Errorf("resp.Result.Message=%v, want %v", resp.Result.Message, ErrPodHasOwnerLabel)
+	}
+	if resp.UID != "abc123" {
+		t.Errorf("resp.UID=%v, want abc123", resp.UID)
+	}
+}
+
+func Test_max_ops_guard(t *testing.T) {
+	MaxOps = 2
+	defer func() { MaxOps = 100 }()
+	many := func(*corev1.Pod) ([]operation, error) {
+		return []operation{addOp("/a", 1), addOp("/b", 2), addOp("/c", 3)}, nil
+	}
+	r := post(&v1.AdmissionReview{Request: &v1.AdmissionRequest{Namespace: "default", Resource: resourcePods, Object: tidePod()}})
+	w := httptest.NewRecorder()
+	podPatch(w, r, many, DefaultFailPolicy)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("w.Code=%v, want StatusInternalServerError", w.Code)
+	}
+	if !strings.HasPrefix(w.Body.String(), "too many patch operations: 4 exceeds 2") {
+		t.Errorf("w.Body starts with <%v>, want `too many patch operations: 4 exceeds 2`", w.Body.String())
+	}
+}
+
+func Test_max_ops_guard_counts_stamp(t *testing.T) {
+	defer func() { MaxOps = 100 }()
+	two := func(*corev1.Pod) ([]operation, error) {
+		return []operation{addOp("/a", 1), addOp("/b", 2)}, nil
+	}
+	// the stamp adds the annotations object to a pod without annotations making 3 ops.
+	cases := map[string]struct {
+		maxOps int
+		err    string
+	}{
+		"at the limit":   {3, ""},
+		"over the limit": {2, "too many patch operations: 3 exceeds 2"},
+	}
+
+	for n, tc := range cases {
+		tc := tc
+		t.Run(n, func(t *testing.T) {
+			MaxOps = tc.maxOps
+			resp, err := reviewPod("/two", &v1.AdmissionRequest{Namespace: "default", Resource: resourcePods, Object: tidePod()}, two, DefaultFailPolicy)
+			if tc.err != "" {
+				if err == nil || err.Error() != tc.err {
+					t.Errorf("err=%v, want %v", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("err=%v, want nil", err)
+			}
+			var ops []operation
+			_ = json.Unmarshal(resp.Patch, &ops)
+			if len(ops) != 3 {
+				t.Errorf("len(ops)=%v, want 3", len(ops))
+			}
+		})
+	}
+}
+
+func Test_max_patch_bytes_guard(t *testing.T) {
+	MaxPatchBytes = 1024
+	defer func() { MaxPatchBytes = 1 << 20 }()
+	large := AnnotationsPatch(map[string]string{"majortom.io/blob": strings.Repeat("x", 2048)})
+	r := post(&v1.AdmissionReview{Request: &v1.AdmissionRequest{Namespace: "default", Resource: resourcePods, Object: tidePod()}})
+	w := httptest.NewRecorder()
+	podPatch(w, r, large, DefaultFailPolicy)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("w.Code=%v, want StatusInternalServerError", w.Code)
+	}
+	if !strings.HasPrefix(w.Body.String(), "patch too large") {
+		t.Errorf("w.Body starts with <%v>, want `patch too large`", w.Body.String())
+	}
+}
+
+func Test_route_fail_policy_overrides_default(t *testing.T) {
+	path := writeConfig(t, tempDir(t), "majortom.yaml", `
+routes:
+  - path: /security
+    owner: security
+    failPolicy: closed
+  - path: /labels
+    owner: platform
+    failPolicy: open
+  - path: /default
+    owner: platform
+`)
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig err=%v, want nil", err)
+	}
+	rt := cfg.Router()
+	cases := map[string]struct {
+		code    int
+		allowed bool
+	}{
+		"/security": {http.StatusOK, false},
+		"/labels":   {http.StatusOK, true},
+		"/default":  {http.StatusForbidden, false},
+	}
+
+	for route, tc := range cases {
+		route, tc := route, tc
+		t.Run(route, func(t *testing.T) {
+			r := post(&v1.AdmissionReview{Request: &v1.AdmissionRequest{UID: "abc123", Namespace: "default", Resource: resourcePods, Object: podWithOwnerLabel()}})
+			r.URL.Path = route
+			w := httptest.NewRecorder()
+			rt.mux.ServeHTTP(w, r)
+			if w.Code != tc.code {
+				t.Fatalf("w.Code=%v, want %v", w.Code, tc.code)
+			}
+			if tc.code != http.StatusOK {
+				return
+			}
+			resp := decodeResponse(t, w)
+			if resp.Allowed != tc.allowed {
+				t.Errorf("resp.Allowed=%v, want %v", resp.Allowed, tc.allowed)
+			}
+		})
+	}
+}
+
+func Test_FailPolicy_UnmarshalJSON_invalid(t *testing.T) {
+	var p FailPolicy
+	err := json.Unmarshal([]byte(`"ajar"`), &p)
+	if err == nil {
+		t.Error("err=nil, want error")
+	}
+}
+
+func Test_failPolicy_innermost_wins(t *testing.T) {
+	failing := func(*corev1.Pod) ([]operation, error) {
+		return nil, ErrPodHasOwnerLabel
+	}
+	nested := WithFailPolicy(FailOpen, WithFailPolicy(FailClosed, failing))
+	_, err := nested(&corev1.Pod{})
+	if err == nil {
+		t.Fatal("err=nil, want error")
+	}
+	policy := failPolicy(err, FailWebhook)
+	if policy != FailClosed {
+		t.Errorf("failPolicy()=%v, want closed", policy)
+	}
+	policy = failPolicy(ErrPodHasOwnerLabel, FailOpen)
+	if policy != FailOpen {
+		t.Errorf("failPolicy()=%v, want open", policy)
+	}
+}
+
+func Test_route_fail_policy_applies_to_guards(t *testing.T) {
+	MaxOps = 1
+	defer func() { MaxOps = 100 }()
+	path := writeConfig(t, tempDir(t), "majortom.yaml", `
+routes:
+  - path: /labels
+    owner: platform
+    annotations:
+      majortom.io/team: platform
+    failPolicy: open
+`)
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig err=%v, want nil", err)
+	}
+	r := post(&v1.AdmissionReview{Request: &v1.AdmissionRequest{UID: "abc123", Namespace: "default", Resource: resourcePods, Object: tidePod()}})
+	r.URL.Path = "/labels"
+	w := httptest.NewRecorder()
+	cfg.Router().mux.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("w.Code=%v, want StatusOK", w.Code)
+	}
+	resp := decodeResponse(t, w)
+	if !resp.Allowed || resp.Patch != nil {
+		t.Errorf("resp=%#v, want allowed without patch", resp)
+	}
+}
@@ -0,0 +1,67 @@
+package majortom
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// envFieldPaths are the downward API fields that can be referenced by an env var fieldRef.
+var envFieldPaths = map[string]bool{
+	"metadata.name":           true,
+	"metadata.namespace":      true,
+	"metadata.uid":            true,
+	"spec.nodeName":           true,
+	"spec.serviceAccountName": true,
+	"status.hostIP":           true,
+	"status.podIP":            true,
+	"status.podIPs":           true,
+}
+
+var subscriptPath = regexp.MustCompile(`^metadata\.(labels|annotations)\['(.+)'\]$`)
+
+var ErrInvalidFieldPath = fmt.Errorf("invalid fieldPath")
+
+var ErrFieldPathNotAllowed = fmt.Errorf("fieldPath not allowed")
+
+// ValidateFieldPath returns an error when path can't be referenced by a downward API env var. Labels and annotations
+// are referenced with a subscript, e.g. metadata.labels['app'].
+func ValidateFieldPath(path string) error {
+	if envFieldPaths[path] {
+		return nil
+	}
+	m := subscriptPath.FindStringSubmatch(path)
+	if m == nil {
+		return fmt.Errorf("%w %q", ErrInvalidFieldPath, path)
+	}
+	errs := validation.IsQualifiedName(m[2])
+	if len(errs) > 0 {
+		return fmt.Errorf("%w %q: %s", ErrInvalidFieldPath, path, strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// AllowedFieldPath returns an error when FieldPathAllowlist isn't empty and doesn't contain path. An entry of
+// metadata.labels or metadata.annotations allows all of their subscripts.
+func AllowedFieldPath(path string) error {
+	if len(FieldPathAllowlist) == 0 || FieldPathAllowlist[path] {
+		return nil
+	}
+	m := subscriptPath.FindStringSubmatch(path)
+	if m != nil && FieldPathAllowlist["metadata."+m[1]] {
+		return nil
+	}
+	return fmt.Errorf("%w %q", ErrFieldPathNotAllowed, path)
+}
+
+// checkFieldPath returns an error when path is invalid or not allowed so config authors can't reference fields
+// outside the allowlist.
+func checkFieldPath(path string) error {
+	err := ValidateFieldPath(path)
+	if err != nil {
+		return err
+	}
+	return AllowedFieldPath(path)
+}
@@ -0,0 +1,119 @@
+package majortom
+
+import (
+	"errors"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_ValidateFieldPath(t *testing.T) {
+	cases := map[string]bool{
+		"status.hostIP":                         true,
+		"spec.nodeName":                         true,
+		"metadata.labels['app']":                true,
+		"metadata.annotations['majortom.io/x']": true,
+		"metadata.labels":                       false,
+		"metadata.labels[app]":                  false,
+		"metadata.labels['bad key']":            false,
+		"metadata.labels['App']":                true,
+		"metadata.labels['Majortom.IO/x']":      false,
+		"spec.containers":                       false,
+		"":                                      false,
+	}
+
+	for path, valid := range cases {
+		err := ValidateFieldPath(path)
+		if valid && err != nil {
+			t.Errorf("ValidateFieldPath(%q)=%v, want nil", path, err)
+		}
+		if !valid && !errors.Is(err, ErrInvalidFieldPath) {
+			t.Errorf("ValidateFieldPath(%q)=%v, want ErrInvalidFieldPath", path, err)
+		}
+	}
+}
+
+func Test_VarPatch_label_subscript(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "nginx"}},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Image: "nginx:latest"}}},
+	}
+	ops, err := VarPatch("APP", "metadata.labels['app']")(pod)
+	if err != nil {
+		t.Fatalf("err=%v, want nil", err)
+	}
+	patched := applyPatch(t, pod, ops)
+	env := patched.Spec.Containers[0].Env
+	if len(env) != 1 || env[0].ValueFrom.FieldRef.FieldPath != "metadata.labels['app']" {
+		t.Errorf("env=%#v, want fieldPath metadata.labels['app']", env)
+	}
+}
+
+func Test_VarPatch_invalid_field_path(t *testing.T) {
+	_, err := VarPatch("APP", "spec.containers")(threeContainerPod())
+	if !errors.Is(err, ErrInvalidFieldPath) {
+		t.Errorf("err=%v, want ErrInvalidFieldPath", err)
+	}
+}
+
+func withFieldPathAllowlist(t *testing.T, paths ...string) {
+	FieldPathAllowlist = stringSet{}
+	for _, p := range paths {
+		FieldPathAllowlist[p] = true
+	}
+	t.Cleanup(func() { FieldPathAllowlist = stringSet{} })
+}
+
+func Test_AllowedFieldPath(t *testing.T) {
+	withFieldPathAllowlist(t, "status.hostIP", "metadata.labels")
+	cases := map[string]bool{
+		"status.hostIP":                     true,
+		"metadata.labels['app']":            true,
+		"spec.nodeName":                     false,
+		"metadata.annotations['secret']":    false,
+		"metadata.annotations['labels']":    false,
+		"metadata.labels['app.kubernetes']": true,
+	}
+
+	for path, allowed := range cases {
+		err := AllowedFieldPath(path)
+		if allowed && err != nil {
+			t.Errorf("AllowedFieldPath(%q)=%v, want nil", path, err)
+		}
+		if !allowed && !errors.Is(err, ErrFieldPathNotAllowed) {
+			t.Errorf("AllowedFieldPath(%q)=%v, want ErrFieldPathNotAllowed", path, err)
+		}
+	}
+}
+
+func Test_VarPatch_field_path_not_allowed(t *testing.T) {
+	withFieldPathAllowlist(t, "status.hostIP")
+	pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Image: "nginx:latest"}}}}
+	_, err := VarPatch("SECRET", "metadata.annotations['secret']")(pod)
+	if !errors.Is(err, ErrFieldPathNotAllowed) {
+		t.Errorf("err=%v, want ErrFieldPathNotAllowed", err)
+	}
+}
+
+func Test_Config_field_path_allowlist(t *testing.T) {
+	withFieldPathAllowlist(t, "status.hostIP")
+	cases := map[string]struct {
+		fieldPath string
+		err       error
+	}{
+		"allowed":     {"status.hostIP", nil},
+		"not allowed": {"metadata.annotations['secret']", ErrFieldPathNotAllowed},
+	}
+
+	for n, tc := range cases {
+		tc := tc
+		t.Run(n, func(t *testing.T) {
+			content := "routes:\n  - path: /vars\n    vars:\n      VALUE: \"" + tc.fieldPath + "\""
+			_, err := LoadConfig(writeConfig(t, tempDir(t), "majortom.yaml", content))
+			if !errors.Is(err, tc.err) {
+				t.Errorf("LoadConfig err=%v, want %v", err, tc.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package majortom
+
+import (
+	"net"
+	"sort"
+	"strings"
+)
+
+// stringSet is a flag.Value of comma separated strings.
+type stringSet map[string]bool
+
+func (s stringSet) String() string {
+	values := make([]string, 0, len(s))
+	for v := range s {
+		values = append(values, v)
+	}
+	sort.Strings(values)
+	return strings.Join(values, ",")
+}
+
+// Set adds each of the comma separated values in v.
+func (s stringSet) Set(v string) error {
+	for _, value := range strings.Split(v, ",") {
+		value = strings.TrimSpace(value)
+		if value != "" {
+			s[value] = true
+		}
+	}
+	return nil
+}
+
+// cidrList is a flag.Value of comma separated CIDRs.
+type cidrList []*net.IPNet
+
+func (c *cidrList) String() string {
+	values := make([]string, 0, len(*c))
+	for _, n := range *c {
+		values = append(values, n.String())
+	}
+	return strings.Join(values, ",")
+}
+
+// Set parses and adds each of the comma separated CIDRs in v.
+func (c *cidrList) Set(v string) error {
+	for _, value := range strings.Split(v, ",") {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		_, n, err := net.ParseCIDR(value)
+		if err != nil {
+			return err
+		}
+		*c = append(*c, n)
+	}
+	return nil
+}
+
+// contains reports whether ip is within any of the CIDRs.
+func (c cidrList) contains(ip net.IP) bool {
+	for _, n := range c {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,162 @@
+package majortom
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/admission/v1"
+)
+
+// GRPCAddr is the address the gRPC admission service listens on, empty disables it.
+var GRPCAddr = ""
+
+// GRPCClientCAPath is a PEM bundle of the CAs gRPC clients must present a certificate from, empty accepts any client.
+var GRPCClientCAPath = ""
+
+// RouteMetadata is the gRPC metadata key naming the route whose patchable reviews the request, e.g. /labels/owner.
+const RouteMetadata = "majortom-route"
+
+// AdmissionServer is the gRPC admission service. Requests and responses are the protobuf encodings of the
+// admission.k8s.io/v1 types so no generated stubs are needed, clients invoke /majortom.Admission/Review.
+type AdmissionServer interface {
+	Review(context.Context, *v1.AdmissionRequest) (*v1.AdmissionResponse, error)
+}
+
+// grpcReviewer reviews requests with the mutate route patchables of the current router, sharing the HTTP path's
+// admission logic.
+type grpcReviewer struct {
+	router func() *Router
+}
+
+func (s *grpcReviewer) Review(ctx context.Context, req *v1.AdmissionRequest) (*v1.AdmissionResponse, error) {
+	var route string
+	md, ok := metadata.FromIncomingContext(ctx)
+	if ok && len(md.Get(RouteMetadata)) > 0 {
+		route = md.Get(RouteMetadata)[0]
+	}
+	rt := s.router()
+	// other kinds of route depend on HTTP handler behaviour beyond the patchable.
+	apply, ok := rt.patchables[route]
+	if !ok || rt.kinds[route] != "mutate" {
+		LevelWarn.Printf("status=failed transport=grpc path=%s err='unknown route'", route)
+		return nil, status.Errorf(codes.NotFound, "unknown mutate route %q", route)
+	}
+
+	resp, err := reviewPod(route, req, apply, rt.policy(route)())
+	if err != nil {
+		return nil, status.Error(grpcCode(err), err.Error())
+	}
+	return resp, nil
+}
+
+// grpcCode is the gRPC equivalent of the HTTP status of a ReviewError.
+func grpcCode(err error) codes.Code {
+	re, ok := err.(*ReviewError)
+	if !ok {
+		return codes.Unknown
+	}
+	switch re.Code {
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusInternalServerError:
+		return codes.Internal
+	}
+	return codes.Unknown
+}
+
+var admissionServiceDesc = grpc.ServiceDesc{
+	ServiceName: "majortom.Admission",
+	HandlerType: (*AdmissionServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "Review", Handler: reviewHandler},
+	},
+	Streams: []grpc.StreamDesc{},
+}
+
+func reviewHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := &v1.AdmissionRequest{}
+	err := dec(in)
+	if err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdmissionServer).Review(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/majortom.Admission/Review"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdmissionServer).Review(ctx, req.(*v1.AdmissionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// NewGRPCServer returns a gRPC server exposing the admission service for the routes of router.
+func NewGRPCServer(router func() *Router, opts ...grpc.ServerOption) *grpc.Server {
+	s := grpc.NewServer(opts...)
+	s.RegisterService(&admissionServiceDesc, &grpcReviewer{router: router})
+	return s
+}
+
+// grpcTLSConfig returns the TLS config of the admission service using the webhook's certificate, clients must
+// present a certificate signed by a CA in clientCAPath unless it's empty.
+func grpcTLSConfig(certPath, keyPath, clientCAPath string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if clientCAPath == "" {
+		return cfg, nil
+	}
+	pem, err := ioutil.ReadFile(clientCAPath)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in client CA %s", clientCAPath)
+	}
+	cfg.ClientCAs = pool
+	cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	return cfg, nil
+}
+
+// listenGRPC listens on addr returning the admission service to serve on it, see grpcTLSConfig.
+func listenGRPC(addr, certPath, keyPath, clientCAPath string, router func() *Router) (*grpc.Server, net.Listener, error) {
+	cfg, err := grpcTLSConfig(certPath, keyPath, clientCAPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	return NewGRPCServer(router, grpc.Creds(credentials.NewTLS(cfg))), l, nil
+}
+
+// stopGRPC stops s once in-flight RPCs complete, those still running after timeout are cancelled.
+func stopGRPC(s *grpc.Server, timeout time.Duration) {
+	stopped := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(timeout):
+		LevelWarn.Printf("status=failed transport=grpc err='graceful stop timed out after %s'", timeout)
+		s.Stop()
+	}
+}
@@ -0,0 +1,166 @@
+package majortom
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	v1 "k8s.io/api/admission/v1"
+)
+
+func grpcClient(t *testing.T, rt *Router) *grpc.ClientConn {
+	l := bufconn.Listen(1 << 20)
+	s := NewGRPCServer(func() *Router { return rt })
+	go func() { _ = s.Serve(l) }()
+	t.Cleanup(s.Stop)
+	dial := func(context.Context, string) (net.Conn, error) { return l.Dial() }
+	conn, err := grpc.Dial("bufnet", grpc.WithContextDialer(dial), grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("Dial err=%v, want nil", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+func Test_grpc_Review(t *testing.T) {
+	rt := NewRouter()
+	rt.Handle("/labels/owner", AddOwner)
+	conn := grpcClient(t, rt)
+	cases := map[string]struct {
+		route   string
+		req     *v1.AdmissionRequest
+		code    codes.Code
+		allowed bool
+		patch   bool
+	}{
+		"happy path":       {"/labels/owner", &v1.AdmissionRequest{UID: "abc123", Namespace: "default", Resource: resourcePods, Object: tidePod()}, codes.OK, true, true},
+		"pod with owner":   {"/labels/owner", &v1.AdmissionRequest{UID: "abc123", Namespace: "default", Resource: resourcePods, Object: podWithOwnerLabel()}, codes.PermissionDenied, false, false},
+		"system namespace": {"/labels/owner", &v1.AdmissionRequest{UID: "abc123", Namespace: "kube-system", Resource: resourcePods, Object: tidePod()}, codes.OK, true, false},
+		"unknown route":    {"/labels/team", &v1.AdmissionRequest{UID: "abc123", Namespace: "default", Resource: resourcePods, Object: tidePod()}, codes.NotFound, false, false},
+	}
+
+	for n, tc := range cases {
+		tc := tc
+		t.Run(n, func(t *testing.T) {
+			ctx := metadata.AppendToOutgoingContext(context.Background(), RouteMetadata, tc.route)
+			var resp v1.AdmissionResponse
+			err := conn.Invoke(ctx, "/majortom.Admission/Review", tc.req, &resp)
+			if status.Code(err) != tc.code {
+				t.Fatalf("code=%v, want %v (err=%v)", status.Code(err), tc.code, err)
+			}
+			if err != nil {
+				return
+			}
+			if resp.UID != "abc123" {
+				t.Errorf("resp.UID=%v, want abc123", resp.UID)
+			}
+			if resp.Allowed != tc.allowed {
+				t.Errorf("resp.Allowed=%v, want %v", resp.Allowed, tc.allowed)
+			}
+			if (resp.Patch != nil) != tc.patch {
+				t.Errorf("patched=%v, want %v", resp.Patch != nil, tc.patch)
+			}
+		})
+	}
+}
+
+// selfSigned writes a self-signed certificate and its key to dir returning their paths.
+func selfSigned(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey err=%v, want nil", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "majortom"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate err=%v, want nil", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalECPrivateKey err=%v, want nil", err)
+	}
+	certPath := writeConfig(t, dir, "tls.crt", string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})))
+	keyPath := writeConfig(t, dir, "tls.key", string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})))
+	return certPath, keyPath
+}
+
+func Test_grpcTLSConfig(t *testing.T) {
+	dir := tempDir(t)
+	certPath, keyPath := selfSigned(t, dir)
+	cases := map[string]struct {
+		clientCA   string
+		clientAuth tls.ClientAuthType
+		fails      bool
+	}{
+		"any client": {"", tls.NoClientCert, false},
+		"client CA":  {certPath, tls.RequireAndVerifyClientCert, false},
+		"missing CA": {dir + "/missing.crt", tls.NoClientCert, true},
+		"key as CA":  {keyPath, tls.NoClientCert, true},
+	}
+
+	for n, tc := range cases {
+		tc := tc
+		t.Run(n, func(t *testing.T) {
+			cfg, err := grpcTLSConfig(certPath, keyPath, tc.clientCA)
+			if tc.fails {
+				if err == nil {
+					t.Error("err=nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("err=%v, want nil", err)
+			}
+			if cfg.ClientAuth != tc.clientAuth {
+				t.Errorf("cfg.ClientAuth=%v, want %v", cfg.ClientAuth, tc.clientAuth)
+			}
+		})
+	}
+}
+
+func Test_stopGRPC(t *testing.T) {
+	l := bufconn.Listen(1 << 20)
+	s := NewGRPCServer(func() *Router { return NewRouter() })
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(l) }()
+	dial := func(context.Context, string) (net.Conn, error) { return l.Dial() }
+	conn, err := grpc.Dial("bufnet", grpc.WithContextDialer(dial), grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("Dial err=%v, want nil", err)
+	}
+	defer func() { _ = conn.Close() }()
+	// a completed RPC ensures Serve is running before it's stopped.
+	var resp v1.AdmissionResponse
+	_ = conn.Invoke(context.Background(), "/majortom.Admission/Review", &v1.AdmissionRequest{}, &resp)
+
+	stopGRPC(s, time.Second)
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("Serve err=%v, want nil", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("Serve still running, want stopped")
+	}
+}
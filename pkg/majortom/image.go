@@ -0,0 +1,35 @@
+package majortom
+
+import "strings"
+
+// DefaultRegistry is the registry of image references that don't name one.
+const DefaultRegistry = "docker.io"
+
+// parseImageRef splits a container image reference into its registry, repository, tag and digest following the
+// docker normalisation rules: the first path component names the registry only when it contains a dot or port or is
+// localhost, and official docker.io images live under library/. Tag and digest are empty when omitted, the runtime's
+// implied latest tag is left to the caller.
+func parseImageRef(image string) (registry, repo, tag, digest string) {
+	name := image
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, digest = name[:i], name[i+1:]
+	}
+
+	registry = DefaultRegistry
+	if i := strings.Index(name, "/"); i >= 0 {
+		host := name[:i]
+		if strings.ContainsAny(host, ".:") || host == "localhost" {
+			registry, name = host, name[i+1:]
+		}
+	}
+
+	// with the registry removed any remaining colon separates the tag.
+	if i := strings.LastIndex(name, ":"); i >= 0 {
+		name, tag = name[:i], name[i+1:]
+	}
+
+	if registry == DefaultRegistry && !strings.Contains(name, "/") {
+		name = "library/" + name
+	}
+	return registry, name, tag, digest
+}
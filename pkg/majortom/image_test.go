@@ -0,0 +1,33 @@
+package majortom
+
+import "testing"
+
+func Test_parseImageRef(t *testing.T) {
+	type ref struct{ registry, repo, tag, digest string }
+	digest := "sha256:2a9865e55c37293b71df051922022898d8e4ec0f579c9b53a0caee1b170bc81c"
+	cases := map[string]struct {
+		image string
+		want  ref
+	}{
+		"library":          {"nginx", ref{"docker.io", "library/nginx", "", ""}},
+		"library tag":      {"nginx:1.19.2", ref{"docker.io", "library/nginx", "1.19.2", ""}},
+		"docker.io org":    {"fluent/fluent-bit:1.5", ref{"docker.io", "fluent/fluent-bit", "1.5", ""}},
+		"registry":         {"gcr.io/distroless/static", ref{"gcr.io", "distroless/static", "", ""}},
+		"port in registry": {"registry.local:5000/team/app", ref{"registry.local:5000", "team/app", "", ""}},
+		"port and tag":     {"registry.local:5000/team/app:v1", ref{"registry.local:5000", "team/app", "v1", ""}},
+		"localhost":        {"localhost/app:dev", ref{"localhost", "app", "dev", ""}},
+		"digest":           {"nginx@" + digest, ref{"docker.io", "library/nginx", "", digest}},
+		"tag and digest":   {"registry.local:5000/app:v1@" + digest, ref{"registry.local:5000", "app", "v1", digest}},
+	}
+
+	for name, tc := range cases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			var got ref
+			got.registry, got.repo, got.tag, got.digest = parseImageRef(tc.image)
+			if got != tc.want {
+				t.Errorf("parseImageRef(%q)=%+v, want %+v", tc.image, got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package majortom
+
+import (
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	ManagedByLabel = "app.kubernetes.io/managed-by"
+	PartOfLabel    = "app.kubernetes.io/part-of"
+)
+
+// RecommendedLabelsPatch adds the Kubernetes recommended managed-by and part-of labels when they're absent.
+func RecommendedLabelsPatch(partOf string) PodPatchable {
+	return func(pod *corev1.Pod) ([]operation, error) {
+		entries := map[string]string{}
+		_, ok := pod.ObjectMeta.Labels[ManagedByLabel]
+		if !ok {
+			entries[ManagedByLabel] = "majortom"
+		}
+		_, ok = pod.ObjectMeta.Labels[PartOfLabel]
+		if !ok {
+			entries[PartOfLabel] = partOf
+		}
+		return mapEntriesOps(labelsPath, pod.ObjectMeta.Labels, entries), nil
+	}
+}
+
+// LabelsPatch sets labels on the pod leaving any other labels untouched.
+func LabelsPatch(labels map[string]string) PodPatchable {
+	return func(pod *corev1.Pod) ([]operation, error) {
+		return mapEntriesOps(labelsPath, pod.ObjectMeta.Labels, changedEntries(pod.ObjectMeta.Labels, labels)), nil
+	}
+}
+
+// AnnotationsPatch sets annotations on the pod leaving any other annotations untouched.
+func AnnotationsPatch(annotations map[string]string) PodPatchable {
+	return func(pod *corev1.Pod) ([]operation, error) {
+		return mapEntriesOps(annotationsPath, pod.ObjectMeta.Annotations, changedEntries(pod.ObjectMeta.Annotations, annotations)), nil
+	}
+}
+
+// changedEntries returns the entries whose value differs from existing.
+func changedEntries(existing, entries map[string]string) map[string]string {
+	changed := map[string]string{}
+	for k, v := range entries {
+		current, ok := existing[k]
+		if !ok || current != v {
+			changed[k] = v
+		}
+	}
+	return changed
+}
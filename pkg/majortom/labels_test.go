@@ -0,0 +1,131 @@
+package majortom
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_RecommendedLabelsPatch(t *testing.T) {
+	cases := map[string]struct {
+		labels map[string]string
+		ops    int
+		want   map[string]string
+	}{
+		"none": {nil, 1, map[string]string{ManagedByLabel: "majortom", PartOfLabel: "shop"}},
+		"some": {map[string]string{PartOfLabel: "checkout"}, 1, map[string]string{ManagedByLabel: "majortom", PartOfLabel: "checkout"}},
+		"all":  {map[string]string{ManagedByLabel: "helm", PartOfLabel: "checkout"}, 0, map[string]string{ManagedByLabel: "helm", PartOfLabel: "checkout"}},
+	}
+
+	for n, tc := range cases {
+		tc := tc
+		t.Run(n, func(t *testing.T) {
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Labels: tc.labels}}
+			ops, err := RecommendedLabelsPatch("shop")(pod)
+			if err != nil {
+				t.Fatalf("err=%v, want nil", err)
+			}
+			if len(ops) != tc.ops {
+				t.Errorf("len(ops)=%v, want %v", len(ops), tc.ops)
+			}
+			patched := applyPatch(t, pod, ops)
+			if !cmp.Equal(patched.Labels, tc.want) {
+				t.Errorf("labels mismatch (+want -got)\n%s", cmp.Diff(patched.Labels, tc.want))
+			}
+		})
+	}
+}
+
+func Test_RecommendedLabelsPatch_escapes_pointer(t *testing.T) {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{PartOfLabel: "checkout"}}}
+	ops, _ := RecommendedLabelsPatch("shop")(pod)
+	expected := "/metadata/labels/app.kubernetes.io~1managed-by"
+	if len(ops) != 1 || ops[0].Path != expected {
+		t.Errorf("ops=%#v, want path %v", ops, expected)
+	}
+}
+
+func Test_SandboxPatch(t *testing.T) {
+	cases := map[string]struct {
+		labels map[string]string
+		want   string
+	}{
+		"label present":   {map[string]string{"sandbox": "gvisor"}, "true"},
+		"label different": {map[string]string{"sandbox": "none"}, ""},
+		"label absent":    {nil, ""},
+	}
+
+	for n, tc := range cases {
+		tc := tc
+		t.Run(n, func(t *testing.T) {
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Labels: tc.labels, Annotations: map[string]string{"app": "nginx"}}}
+			ops, err := SandboxPatch("sandbox", "gvisor", SandboxAnnotation, "true")(pod)
+			if err != nil {
+				t.Fatalf("err=%v, want nil", err)
+			}
+			patched := applyPatch(t, pod, ops)
+			if patched.Annotations[SandboxAnnotation] != tc.want {
+				t.Errorf("annotations[%s]=%v, want %v", SandboxAnnotation, patched.Annotations[SandboxAnnotation], tc.want)
+			}
+			if patched.Annotations["app"] != "nginx" {
+				t.Errorf("annotations[app]=%v, want nginx", patched.Annotations["app"])
+			}
+		})
+	}
+}
+
+func Test_SandboxPatch_escapes_annotation_pointer(t *testing.T) {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"sandbox": "gvisor"}, Annotations: map[string]string{}}}
+	ops, _ := SandboxPatch("sandbox", "gvisor", "example.com/sandbox", "true")(pod)
+	expected := "/metadata/annotations/example.com~1sandbox"
+	if len(ops) != 1 || ops[0].Path != expected {
+		t.Errorf("ops=%#v, want path %v", ops, expected)
+	}
+}
+
+func Test_LabelsPatch_and_AnnotationsPatch_merge_with_existing(t *testing.T) {
+	cases := map[string]struct {
+		patch    func(map[string]string) PodPatchable
+		base     string
+		existing func(*corev1.Pod) map[string]string
+	}{
+		"labels":      {LabelsPatch, labelsPath, func(p *corev1.Pod) map[string]string { return p.Labels }},
+		"annotations": {AnnotationsPatch, annotationsPath, func(p *corev1.Pod) map[string]string { return p.Annotations }},
+	}
+
+	for n, tc := range cases {
+		tc := tc
+		t.Run(n, func(t *testing.T) {
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+				Labels:      map[string]string{"app": "tide", "team": "payments"},
+				Annotations: map[string]string{"app": "tide", "team": "payments"},
+			}}
+			ops, err := tc.patch(map[string]string{"team": "platform", "tier": "web"})(pod)
+			if err != nil {
+				t.Fatalf("err=%v, want nil", err)
+			}
+			if hasPath(ops, tc.base) {
+				t.Errorf("ops=%v, want per key ops under existing %v", ops, tc.base)
+			}
+			patched := applyPatch(t, pod, ops)
+			expected := map[string]string{"app": "tide", "team": "platform", "tier": "web"}
+			actual := tc.existing(patched)
+			if !cmp.Equal(actual, expected) {
+				t.Errorf("%s mismatch (+want -got)\n%s", n, cmp.Diff(actual, expected))
+			}
+		})
+	}
+}
+
+func Test_LabelsPatch_creates_labels_when_nil(t *testing.T) {
+	pod := &corev1.Pod{}
+	ops, err := LabelsPatch(map[string]string{"tier": "web"})(pod)
+	if err != nil {
+		t.Fatalf("err=%v, want nil", err)
+	}
+	if len(ops) != 1 || ops[0].Path != labelsPath {
+		t.Errorf("ops=%v, want single add of %v", ops, labelsPath)
+	}
+}
@@ -0,0 +1,54 @@
+package majortom
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Level is a logging verbosity, messages are emitted when their level is at or below LogLevel.
+type Level int
+
+const (
+	LevelError Level = iota
+	LevelWarn
+	LevelInfo
+	LevelDebug
+)
+
+// LogLevel is the maximum verbosity that is logged.
+var LogLevel = LevelInfo
+
+var levelNames = []string{"error", "warn", "info", "debug"}
+
+func (l Level) String() string {
+	if l < LevelError || l > LevelDebug {
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+// Set parses the level name s for use as a flag.Value.
+func (l *Level) Set(s string) error {
+	for i, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			*l = Level(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown log level %q, want one of %s", s, strings.Join(levelNames, ", "))
+}
+
+// Enabled reports whether messages at l are logged.
+func (l Level) Enabled() bool {
+	return l <= LogLevel
+}
+
+// Printf logs to the standard logger when l is enabled.
+func (l Level) Printf(format string, v ...interface{}) {
+	if !l.Enabled() {
+		return
+	}
+	// calldepth of 2 attributes the file and line to the caller.
+	_ = log.Output(2, fmt.Sprintf(format, v...))
+}
@@ -0,0 +1,43 @@
+package majortom
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/admission/v1"
+)
+
+func Test_Level_Set(t *testing.T) {
+	var l Level
+	err := l.Set("DEBUG")
+	if err != nil {
+		t.Errorf("err=%v, want nil", err)
+	}
+	if l != LevelDebug {
+		t.Errorf("l=%v, want debug", l)
+	}
+	err = l.Set("verbose")
+	if err == nil {
+		t.Error("err=nil, want error")
+	}
+}
+
+func Test_debug_lines_suppressed_at_info(t *testing.T) {
+	cases := map[Level]bool{
+		LevelInfo:  false,
+		LevelDebug: true,
+	}
+
+	for level, logged := range cases {
+		buf := captureLog(t)
+		LogLevel = level
+		r := post(&v1.AdmissionReview{Request: &v1.AdmissionRequest{Namespace: "kube-system"}})
+		podPatch(httptest.NewRecorder(), r, AddOwner, DefaultFailPolicy)
+		LogLevel = LevelInfo
+		actual := strings.Contains(buf.String(), "status=ignored")
+		if actual != logged {
+			t.Errorf("level=%v logged=%v, want %v", level, actual, logged)
+		}
+	}
+}
@@ -0,0 +1,690 @@
+package majortom
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+	v1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	DefaultAddr     = ":8443"
+	DefaultCertPath = "/run/secrets/tls/tls.crt"
+	DefaultKeyPath  = "/run/secrets/tls/tls.key"
+	ApplicationJson = `application/json`
+	ApplicationYaml = `application/yaml`
+)
+
+var (
+	// Revision is the git revision of the binary
+	Revision = "dev"
+
+	// OwnNamespace is the namespace majortom runs in, pods in it are never mutated.
+	OwnNamespace = ""
+
+	// IgnoreOtherResources allows resources other than pods unmodified rather than rejecting them.
+	IgnoreOtherResources = true
+
+	// MaxOps is the maximum number of operations in a pod's patch, including the annotations majortom stamps.
+	MaxOps = 100
+
+	// MaxPatchBytes is the maximum size of the marshalled patch, 0 disables the limit.
+	MaxPatchBytes = 1 << 20
+
+	// VerifyPatches applies each patch to the pod in-memory before responding, failing patches the apiserver would
+	// reject under the DefaultFailPolicy.
+	VerifyPatches = false
+
+	// NamespaceAllowlist when not empty restricts mutation to the listed namespaces, overriding isSystem for all but
+	// OwnNamespace.
+	NamespaceAllowlist = stringSet{}
+
+	// FieldPathAllowlist when not empty restricts the fieldPaths injected vars may reference, see AllowedFieldPath.
+	FieldPathAllowlist = stringSet{}
+
+	// ExcludeTolerations are toleration keys (e.g. node-role.kubernetes.io/control-plane) marking pods that are
+	// allowed unmodified.
+	ExcludeTolerations = stringSet{}
+
+	// ConfigPath is the YAML route config.
+	ConfigPath = ""
+
+	// ReloadInterval is how often the config is reloaded.
+	ReloadInterval = time.Duration(0)
+
+	// JSONErrors writes error responses outside of admission reviews as JSON rather than plain text.
+	JSONErrors = false
+
+	// MaxReloadFailures is the number of consecutive reload failures before majortom reports itself as not live.
+	MaxReloadFailures = 3
+
+	// ShutdownDelay is how long admission requests are refused before the server shuts down on SIGTERM.
+	ShutdownDelay = 5 * time.Second
+
+	// ShutdownTimeout is how long in-flight requests have to complete once the server shuts down.
+	ShutdownTimeout = 10 * time.Second
+
+	// DebugEndpoints serves /preview, /selfcheck and /routes. They're unauthenticated and reveal the route config or
+	// run the patchables on demand so they're off by default.
+	DebugEndpoints = false
+)
+
+var ErrTooManyOps = fmt.Errorf("too many patch operations")
+
+var ErrPatchTooLarge = fmt.Errorf("patch too large")
+
+var ErrInvalidPatch = fmt.Errorf("invalid patch")
+
+const LogFlags = log.LstdFlags | log.LUTC | log.Lshortfile | log.Lmsgprefix
+
+func Exec(addr, certPath, keyPath string) {
+	prefix := fmt.Sprintf("rev=%s ", Revision)
+	log.SetFlags(LogFlags)
+	log.SetPrefix(prefix)
+	lg := log.New(os.Stderr, prefix, LogFlags)
+	stop := make(chan struct{})
+	rl, err := NewReloader(loadRouter, MaxReloadFailures)
+	if err != nil {
+		lg.Fatalln(err)
+	}
+	if ConfigPath != "" && ReloadInterval > 0 {
+		go rl.Watch(ReloadInterval, stop)
+	}
+	if ConfigPath != "" {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go rl.WatchSignal(hup, stop)
+	}
+	server := &http.Server{
+		Addr: addr,
+		Handler: &logger{
+			Handler: serveMux(rl),
+			Logger:  lg,
+		},
+	}
+	logConfigSummary(rl.Router())
+	startInformers(stop)
+	go cacheSync.Wait(stop)
+	serveErr := make(chan error, 2)
+	var gs *grpc.Server
+	if GRPCAddr != "" {
+		var l net.Listener
+		gs, l, err = listenGRPC(GRPCAddr, certPath, keyPath, GRPCClientCAPath, rl.Router)
+		if err != nil {
+			lg.Fatalln(err)
+		}
+		lg.Printf("status=binding transport=grpc addr=%s\n", GRPCAddr)
+		go func() {
+			err := gs.Serve(l)
+			if err != nil {
+				serveErr <- fmt.Errorf("grpc: %w", err)
+			}
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGTERM, os.Interrupt)
+		<-sig
+		err := gracefulShutdown(server, drainer, ShutdownDelay, ShutdownTimeout)
+		if err != nil {
+			lg.Printf("status=failed err='shutdown: %v'\n", err)
+		}
+		if gs != nil {
+			stopGRPC(gs, ShutdownTimeout)
+		}
+		close(stop)
+		close(done)
+	}()
+	lg.Printf("status=binding addr=%s\n", server.Addr)
+	go func() {
+		err := server.ListenAndServeTLS(certPath, keyPath)
+		if err != http.ErrServerClosed {
+			serveErr <- err
+		}
+	}()
+	select {
+	case err := <-serveErr:
+		lg.Fatalln(err)
+	case <-done:
+	}
+	lg.Println("status=stopped")
+}
+
+// serveMux routes admission requests to the current router of rl alongside the operational endpoints, the debug
+// endpoints are only served with DebugEndpoints enabled.
+func serveMux(rl *Reloader) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/", drainer.Wrap(rl))
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/livez", rl.Livez())
+	// draining replicas report unready so they're removed from the webhook service's endpoints.
+	mux.Handle("/readyz", drainer.Wrap(cacheSync.Readyz()))
+	if !DebugEndpoints {
+		return mux
+	}
+	mux.HandleFunc("/preview", func(w http.ResponseWriter, r *http.Request) {
+		rl.Router().Preview().ServeHTTP(w, r)
+	})
+	mux.HandleFunc("/selfcheck", func(w http.ResponseWriter, r *http.Request) {
+		rl.Router().SelfCheckHandler().ServeHTTP(w, r)
+	})
+	mux.HandleFunc("/routes", func(w http.ResponseWriter, r *http.Request) {
+		rl.Router().RoutesHandler().ServeHTTP(w, r)
+	})
+	return mux
+}
+
+// logConfigSummary logs a single line describing the routes of rt and the active configuration so operators can
+// verify the running config at a glance.
+func logConfigSummary(rt *Router) {
+	LevelInfo.Printf("status=configured %s", configSummary(rt))
+}
+
+// configSummary describes the routes of rt and the active configuration as logfmt fields.
+func configSummary(rt *Router) string {
+	system := stringSet{metav1.NamespacePublic: true, metav1.NamespaceSystem: true}
+	if OwnNamespace != "" {
+		system[OwnNamespace] = true
+	}
+	return fmt.Sprintf("routes='%s' system-namespaces=%s namespace-allowlist=%s exclude-tolerations=%s fail-policy=%s max-ops=%d max-patch-bytes=%d config=%s reload-interval=%s max-reload-failures=%d shutdown-delay=%s shutdown-timeout=%s debug-endpoints=%v",
+		rt.Summary(), system, NamespaceAllowlist, ExcludeTolerations, DefaultFailPolicy, MaxOps, MaxPatchBytes, ConfigPath, ReloadInterval, MaxReloadFailures, ShutdownDelay, ShutdownTimeout, DebugEndpoints)
+}
+
+// loadRouter builds the Router from ConfigPath or the default route when no config is specified.
+func loadRouter() (*Router, error) {
+	if ConfigPath == "" {
+		rt := NewRouter()
+		rt.Handle("/labels/owner", VarPatch("NODEIP", "status.hostIP"))
+		rt.Describe("/labels/owner", "vars")
+		return rt, nil
+	}
+	cfg, err := LoadConfig(ConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	return cfg.Router(), nil
+}
+
+var podResource = metav1.GroupVersionResource{Version: "v1", Resource: "pods"}
+
+func closer(c io.Closer) {
+	err := c.Close()
+	if err != nil {
+		LevelError.Printf("error closing body err=%v\n", err)
+	}
+}
+
+var ErrPodHasOwnerLabel = fmt.Errorf("pod has owner")
+
+// DefaultOwner is the owner AddOwner labels pods with.
+const DefaultOwner = "nathan.fisher"
+
+func AddOwner(pod *corev1.Pod) ([]operation, error) {
+	return OwnerPatch(DefaultOwner)(pod)
+}
+
+func varReplace(cid, eid int, name, value string) operation {
+	return varReplaceVersion(cid, eid, name, value, "")
+}
+
+// varReplaceVersion is varReplace with the fieldRef apiVersion, empty omits it.
+func varReplaceVersion(cid, eid int, name, value, apiVersion string) operation {
+	path := fmt.Sprintf("/spec/containers/%d/env/%d", cid, eid)
+	return replaceOp(path, fieldRefVar(name, value, apiVersion))
+}
+
+func varAdd(cid, eid int, name, value string) operation {
+	return varAddVersion(cid, eid, name, value, "")
+}
+
+// varAddVersion is varAdd with the fieldRef apiVersion, empty omits it.
+func varAddVersion(cid, eid int, name, value, apiVersion string) operation {
+	if eid == 0 {
+		path := fmt.Sprintf("/spec/containers/%d/env", cid)
+		return addOp(path, []map[string]interface{}{fieldRefVar(name, value, apiVersion)})
+	}
+	path := EnvAppendStyle.path(fmt.Sprintf("/spec/containers/%d/env", cid), eid)
+	return addOp(path, fieldRefVar(name, value, apiVersion))
+}
+
+// fieldRefVar is the env var name referencing fieldPath value, apiVersion is omitted when empty leaving the
+// apiserver to default it to v1.
+func fieldRefVar(name, value, apiVersion string) map[string]interface{} {
+	fieldRef := map[string]interface{}{
+		"fieldPath": value,
+	}
+	if apiVersion != "" {
+		fieldRef["apiVersion"] = apiVersion
+	}
+	return map[string]interface{}{
+		"name": name,
+		"valueFrom": map[string]interface{}{
+			"fieldRef": fieldRef,
+		},
+	}
+}
+
+func VarPatch(name, value string, opts ...VarOption) PodPatchable {
+	return VarsPatch(map[string]string{name: value}, opts...)
+}
+
+// NodeNameVarPatch injects the env var name with the name of the node the pod is scheduled to.
+func NodeNameVarPatch(name string, opts ...VarOption) PodPatchable {
+	return VarPatch(name, "spec.nodeName", opts...)
+}
+
+// VarsPatch injects each env var name with a valueFrom referencing its fieldPath value. Names are applied in sorted
+// order so the resulting patch is deterministic.
+//
+// Probes can't be rewritten to reference the injected values: $(VAR) expansion only applies to a container's command
+// and args, and httpGet or tcpSocket probes can't reference env at all. Exec probes run inside the container after
+// its env is set though, so a shell command such as `sh -c 'wget -qO- http://$NODEIP:10255/healthz'` sees the
+// injected var. Probes are left untouched.
+func VarsPatch(vars map[string]string, opts ...VarOption) PodPatchable {
+	cfg := newVarConfig(opts)
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	// prefixing preserves the names' order.
+	var nameErr error
+	prefixed := make(map[string]string, len(vars))
+	for i, name := range names {
+		var err error
+		names[i], err = cfg.varName(name)
+		if err != nil && nameErr == nil {
+			nameErr = err
+		}
+		prefixed[names[i]] = vars[name]
+	}
+	vars = prefixed
+	slots := make(map[string]int, len(names))
+	for k, name := range names {
+		slots[name] = k
+	}
+	return func(pod *corev1.Pod) ([]operation, error) {
+		if nameErr != nil {
+			return nil, nameErr
+		}
+		for _, name := range names {
+			err := checkFieldPath(vars[name])
+			if err != nil {
+				return nil, err
+			}
+		}
+		names := names
+		var recorded stringSet
+		if cfg.record {
+			recorded = injectedVars(pod)
+			pending := make([]string, 0, len(names))
+			for _, name := range names {
+				if !recorded[name] {
+					pending = append(pending, name)
+				}
+			}
+			names = pending
+		}
+		var ops []operation
+		found := make([]int, len(slots))
+		for i := range pod.Spec.Containers {
+			container := pod.Spec.Containers[i]
+			if !cfg.selected(i, len(pod.Spec.Containers), &container) {
+				continue
+			}
+			// scan the env once per container looking each name up in slots so injecting k vars is linear rather
+			// than a scan per var, and nothing is allocated per container.
+			for k := range found {
+				found[k] = -1
+			}
+			var dups []int
+			for j := range container.Env {
+				k, ok := slots[container.Env[j].Name]
+				if !ok {
+					continue
+				}
+				if found[k] < 0 {
+					found[k] = j
+					continue
+				}
+				dups = append(dups, j)
+			}
+			var adds []string
+			for _, name := range names {
+				j := found[slots[name]]
+				if j >= 0 {
+					ops = append(ops, varReplaceVersion(i, j, name, vars[name], cfg.apiVersion))
+					continue
+				}
+				adds = append(adds, name)
+			}
+			// remove duplicates after the replaces and last to first so every index emitted stays valid when applied in order.
+			for k := len(dups) - 1; k >= 0; k-- {
+				ops = append(ops, removeOp(fmt.Sprintf("/spec/containers/%d/env/%d", i, dups[k])))
+			}
+			n := len(container.Env) - len(dups)
+			for k, name := range adds {
+				if cfg.prepend && n > 0 {
+					// inserting each at the next index keeps the injected vars in sorted order ahead of the existing.
+					ops = append(ops, addOp(fmt.Sprintf("/spec/containers/%d/env/%d", i, k), fieldRefVar(name, vars[name], cfg.apiVersion)))
+					continue
+				}
+				ops = append(ops, varAddVersion(i, n, name, vars[name], cfg.apiVersion))
+				n++
+			}
+		}
+		if cfg.record && len(ops) > 0 {
+			for _, name := range names {
+				recorded[name] = true
+			}
+			ops = append(ops, mapEntriesOps(annotationsPath, pod.ObjectMeta.Annotations, map[string]string{InjectedVarsAnnotation: recorded.String()})...)
+		}
+		return ops, nil
+	}
+}
+
+type PodPatchable func(*corev1.Pod) ([]operation, error)
+
+func bind(handler func(http.ResponseWriter, *http.Request, PodPatchable, FailPolicy), patchable PodPatchable, policy func() FailPolicy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler(w, r, patchable, policy())
+	}
+}
+
+func podPatch(w http.ResponseWriter, r *http.Request, apply PodPatchable, policy FailPolicy) {
+	review, ok := readRequest(w, r)
+	if !ok {
+		return
+	}
+
+	resp, err := reviewPod(route(r), review.Request, apply, policy)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if LevelDebug.Enabled() {
+		opsHeaders(w, resp)
+	}
+	writeReview(w, r, resp)
+}
+
+// MaxOpsSummary is the maximum length of the X-Majortom-Ops-Summary header.
+const MaxOpsSummary = 256
+
+// opsHeaders sets X-Majortom-Ops to the number of ops in the response's patch and X-Majortom-Ops-Summary to their
+// comma separated op and path, truncated to MaxOpsSummary, so a curl can see what was patched without decoding the
+// review.
+func opsHeaders(w http.ResponseWriter, resp *v1.AdmissionResponse) {
+	var ops []operation
+	if len(resp.Patch) > 0 {
+		err := json.Unmarshal(resp.Patch, &ops)
+		if err != nil {
+			LevelDebug.Printf("status=failed uid=%s err='ops headers: %v'", resp.UID, err)
+			return
+		}
+	}
+	summaries := make([]string, 0, len(ops))
+	for _, op := range ops {
+		summaries = append(summaries, op.Op+" "+op.Path)
+	}
+	summary := strings.Join(summaries, ",")
+	if len(summary) > MaxOpsSummary {
+		// cut on a rune boundary so the header stays valid UTF-8.
+		end := MaxOpsSummary - 3
+		for end > 0 && !utf8.RuneStart(summary[end]) {
+			end--
+		}
+		summary = summary[:end] + "..."
+	}
+	w.Header().Set("X-Majortom-Ops", strconv.Itoa(len(ops)))
+	if summary != "" {
+		w.Header().Set("X-Majortom-Ops-Summary", summary)
+	}
+}
+
+// readRequest decodes an admission review from the HTTP request. When ok is false an error response has been written.
+func readRequest(w http.ResponseWriter, r *http.Request) (review *v1.AdmissionReview, ok bool) {
+	contentType := r.Header.Get("Content-Type")
+	if r.Method != http.MethodPost {
+		LevelWarn.Printf("status=failed path=%s err='invalid request method %s'", r.URL.Path, r.Method)
+		httpError(w, "only POST permitted", http.StatusMethodNotAllowed)
+		return nil, false
+	}
+	defer closer(r.Body)
+
+	if contentType != ApplicationJson && contentType != ApplicationYaml {
+		LevelWarn.Printf("status=failed path=%s err='invalid content-type %s'", r.URL.Path, contentType)
+		httpError(w, "invalid content-type", http.StatusBadRequest)
+		return nil, false
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		LevelWarn.Printf("status=failed path=%s err='admission review read: %v'", r.URL.Path, err)
+		httpError(w, "error reading response body", http.StatusBadRequest)
+		return nil, false
+	}
+
+	// the apiserver always sends JSON, YAML is accepted for test harnesses and converted so both decode the same way.
+	if contentType == ApplicationYaml {
+		body, err = yaml.YAMLToJSON(body)
+		if err != nil {
+			LevelWarn.Printf("status=failed path=%s err='admission review yaml: %v'", r.URL.Path, err)
+			httpError(w, "error reading response body", http.StatusBadRequest)
+			return nil, false
+		}
+	}
+
+	// unmarshal rather than stream decode so truncated bodies report a syntax error with an offset.
+	review = &v1.AdmissionReview{}
+	err = json.Unmarshal(body, review)
+	if err != nil {
+		LevelWarn.Printf("status=failed path=%s err='admission review unmarshal: %s'", r.URL.Path, decodeError(err))
+		httpError(w, "error reading response body", http.StatusBadRequest)
+		return nil, false
+	}
+
+	if review.Request == nil {
+		LevelWarn.Printf("status=failed path=%s err='request was nil'", r.URL.Path)
+		httpError(w, "nil admission request", http.StatusBadRequest)
+		return nil, false
+	}
+
+	return review, true
+}
+
+// httpError writes message and code as plain text or, when JSONErrors is set, as {"error":message,"code":code}.
+func httpError(w http.ResponseWriter, message string, code int) {
+	if !JSONErrors {
+		http.Error(w, message, code)
+		return
+	}
+	w.Header().Set("Content-Type", ApplicationJson)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(code)
+	err := json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+		Code  int    `json:"code"`
+	}{message, code})
+	if err != nil {
+		LevelError.Printf("status=failed err='error response write: %v'", err)
+	}
+}
+
+// writeError responds with the status of a *ReviewError or an internal server error for any other error.
+func writeError(w http.ResponseWriter, err error) {
+	var re *ReviewError
+	if errors.As(err, &re) {
+		httpError(w, re.Message, re.Code)
+		return
+	}
+	httpError(w, err.Error(), http.StatusInternalServerError)
+}
+
+// writeReview encodes resp as an admission review response. The encoder is streamed to w as json.Encoder already
+// buffers the review and issues a single Write, see BenchmarkEncodeResponse.
+func writeReview(w http.ResponseWriter, r *http.Request, resp *v1.AdmissionResponse) {
+	review := v1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{Kind: "AdmissionReview", APIVersion: "admission.k8s.io/v1"},
+		Response: resp,
+	}
+
+	wc := withResponseCode(w)
+	wc.Header().Set("Content-Type", ApplicationJson)
+	enc := json.NewEncoder(wc)
+	enc.SetEscapeHTML(false)
+	err := enc.Encode(&review)
+	if err != nil && wc.wroteHeader {
+		LevelError.Printf("status=failed path=%s err='admission review write: %v'", r.URL.Path, err)
+		return
+	}
+	if err != nil {
+		LevelError.Printf("status=failed path=%s err='admission review marshal: %v'", r.URL.Path, err)
+		httpError(wc, "unable to encode response json", http.StatusInternalServerError)
+		return
+	}
+}
+
+// decodeError adds the offset and field details from JSON decode errors to aid diagnosing malformed reviews.
+func decodeError(err error) string {
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		return fmt.Sprintf("%v offset=%d", e, e.Offset)
+	case *json.UnmarshalTypeError:
+		return fmt.Sprintf("%v offset=%d field=%s", e, e.Offset, e.Field)
+	}
+	return err.Error()
+}
+
+type operation struct {
+	Op   string `json:"op"`
+	Path string `json:"path"`
+	// Value for integer fields should be an int64 or json.Number, never a float64 which loses precision beyond 2^53.
+	Value interface{} `json:"value,omitempty"`
+}
+
+func addOp(path string, value interface{}) operation {
+	return operation{
+		Op:    "add",
+		Path:  path,
+		Value: value,
+	}
+}
+
+func replaceOp(path string, value interface{}) operation {
+	return operation{
+		Op:    "replace",
+		Path:  path,
+		Value: value,
+	}
+}
+
+func removeOp(path string) operation {
+	return operation{
+		Op:   "remove",
+		Path: path,
+	}
+}
+
+// MarshalJSON always includes the value for operations that require one so falsey values such as false, "" and 0
+// aren't dropped, and omits it for operations that don't take one.
+func (o operation) MarshalJSON() ([]byte, error) {
+	switch o.Op {
+	case "add", "replace", "test":
+		return marshal(struct {
+			Op    string      `json:"op"`
+			Path  string      `json:"path"`
+			Value interface{} `json:"value"`
+		}{o.Op, o.Path, o.Value})
+	}
+	return marshal(struct {
+		Op   string `json:"op"`
+		Path string `json:"path"`
+	}{o.Op, o.Path})
+}
+
+// marshal is json.Marshal without HTML escaping so values such as URLs containing & round-trip byte-for-byte.
+func marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	err := enc.Encode(v)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+type responseCode struct {
+	http.ResponseWriter
+	code        int
+	wroteHeader bool
+}
+
+func (w *responseCode) WriteHeader(statusCode int) {
+	w.code = statusCode
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *responseCode) Write(b []byte) (int, error) {
+	// an implicit 200 is sent by the first Write when WriteHeader hasn't been called.
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+func withResponseCode(w http.ResponseWriter) *responseCode {
+	wc, ok := w.(*responseCode)
+	if ok {
+		return wc
+	}
+	return &responseCode{ResponseWriter: w, code: http.StatusOK}
+}
+
+type logger struct {
+	Handler http.Handler
+	Logger  *log.Logger
+}
+
+func (l *logger) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	wc := withResponseCode(w)
+	l.Handler.ServeHTTP(wc, r)
+	if !LevelInfo.Enabled() {
+		return
+	}
+	l.Logger.Printf("status=%d method=%s path=%s remote=%s\n", wc.code, r.Method, r.URL.Path, clientAddr(r))
+}
+
+func isSystem(namespace string) bool {
+	if namespace == metav1.NamespacePublic {
+		return true
+	}
+	if namespace == metav1.NamespaceSystem {
+		return true
+	}
+	if OwnNamespace != "" && namespace == OwnNamespace {
+		return true
+	}
+	return false
+}
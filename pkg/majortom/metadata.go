@@ -0,0 +1,107 @@
+package majortom
+
+import (
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	labelsPath      = "/metadata/labels"
+	annotationsPath = "/metadata/annotations"
+)
+
+// escapePointer escapes a map key for use as a JSON Pointer reference token (RFC 6901).
+func escapePointer(key string) string {
+	return strings.Replace(strings.Replace(key, "~", "~0", -1), "/", "~1", -1)
+}
+
+// mapEntriesOps adds entries under the object at base. When existing is nil the whole object is added as a single
+// op because the apiserver rejects adding a member to an absent parent.
+func mapEntriesOps(base string, existing map[string]string, entries map[string]string) []operation {
+	if len(entries) == 0 {
+		return nil
+	}
+	if existing == nil {
+		value := map[string]interface{}{}
+		for k, v := range entries {
+			value[k] = v
+		}
+		return []operation{addOp(base, value)}
+	}
+
+	keys := make([]string, 0, len(entries))
+	for k := range entries {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var ops []operation
+	for _, k := range keys {
+		ops = append(ops, addOp(base+"/"+escapePointer(k), entries[k]))
+	}
+	return ops
+}
+
+// RevisionAnnotation records the majortom Revision that patched a pod.
+const RevisionAnnotation = "majortom.io/revision"
+
+// revisionOps stamps the RevisionAnnotation alongside ops.
+func revisionOps(pod *corev1.Pod, ops []operation) []operation {
+	return annotationOps(pod, ops, map[string]string{RevisionAnnotation: Revision})
+}
+
+// ProcessedAnnotation records the comma separated routes that patched a pod, a route skips pods it has already
+// processed so a webhook intercepting pods it caused to be created can't loop.
+const ProcessedAnnotation = "majortom.io/processed"
+
+// processedRoute is the route recorded in the ProcessedAnnotation for path, an empty path when reviewed outside a route.
+func processedRoute(path string) string {
+	if path == "" {
+		return "/"
+	}
+	return path
+}
+
+// processed reports whether the pod's ProcessedAnnotation records path.
+func processed(pod *corev1.Pod, path string) bool {
+	routes := stringSet{}
+	_ = routes.Set(pod.ObjectMeta.Annotations[ProcessedAnnotation])
+	return routes[processedRoute(path)]
+}
+
+// stampOps records the Revision and the route path as processed alongside ops.
+func stampOps(pod *corev1.Pod, ops []operation, path string) []operation {
+	routes := stringSet{}
+	_ = routes.Set(pod.ObjectMeta.Annotations[ProcessedAnnotation])
+	routes[processedRoute(path)] = true
+	return annotationOps(pod, ops, map[string]string{RevisionAnnotation: Revision, ProcessedAnnotation: routes.String()})
+}
+
+// annotationOps adds entries to the annotations alongside ops, taking care not to clobber an annotations object
+// added by ops.
+func annotationOps(pod *corev1.Pod, ops []operation, entries map[string]string) []operation {
+	existing := pod.ObjectMeta.Annotations
+	if existing == nil && hasPath(ops, annotationsPath) {
+		existing = map[string]string{}
+	}
+	return mapEntriesOps(annotationsPath, existing, entries)
+}
+
+func hasPath(ops []operation, path string) bool {
+	for _, op := range ops {
+		if op.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+// SandboxAnnotation is the annotation CRI-O consults to run a pod in a sandboxed runtime.
+const SandboxAnnotation = "io.kubernetes.cri-o.TrySandbox"
+
+// SandboxPatch adds the annotation key with value to pods labelled with labelKey=labelValue.
+func SandboxPatch(labelKey, labelValue, key, value string) PodPatchable {
+	return WhenLabel(labelKey, labelValue, AnnotationsPatch(map[string]string{key: value}))
+}
@@ -0,0 +1,111 @@
+package majortom
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var inflight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "majortom_admission_inflight",
+	Help: "Number of admission requests currently being handled.",
+}, []string{"path"})
+
+var patchDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "majortom_patch_compute_duration_seconds",
+	Help:    "Time spent computing patches excluding request decoding and response encoding.",
+	Buckets: prometheus.ExponentialBuckets(0.0001, 4, 8),
+}, []string{"path"})
+
+var patchableErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "majortom_patchable_errors_total",
+	Help: "Number of errors returned by patchables by error kind, see errorLabel.",
+}, []string{"path", "error"})
+
+var invalidPatches = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "majortom_invalid_patch_total",
+	Help: "Number of patches that failed to apply to the pod when verified with -verify-patches.",
+}, []string{"path"})
+
+// NamespaceMetrics enables namespaceAdmissions, off by default as every namespace adds series.
+var NamespaceMetrics = false
+
+// NamespaceMetricsAllowlist are the namespaces counted individually by namespaceAdmissions, all others are counted
+// as "other" to bound cardinality.
+var NamespaceMetricsAllowlist = stringSet{}
+
+var namespaceAdmissions = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "majortom_namespace_admission_total",
+	Help: "Number of patch decisions for reviewed pods by namespace, namespaces outside the allowlist are counted as other.",
+}, []string{"path", "namespace", "decision"})
+
+func init() {
+	prometheus.MustRegister(inflight)
+	prometheus.MustRegister(patchDuration)
+	prometheus.MustRegister(patchableErrors)
+	prometheus.MustRegister(invalidPatches)
+	prometheus.MustRegister(namespaceAdmissions)
+}
+
+// countNamespace counts decision against the namespace when NamespaceMetrics is enabled.
+func countNamespace(path, namespace, decision string) {
+	if !NamespaceMetrics {
+		return
+	}
+	if !NamespaceMetricsAllowlist[namespace] {
+		namespace = "other"
+	}
+	namespaceAdmissions.WithLabelValues(path, namespace, decision).Inc()
+}
+
+// sentinelErrors are the errors counted by name, anything else is counted as internal to bound cardinality.
+var sentinelErrors = []struct {
+	err  error
+	name string
+}{
+	{ErrPodHasOwnerLabel, "ErrPodHasOwnerLabel"},
+	{ErrPodHasOwnersAnnotation, "ErrPodHasOwnersAnnotation"},
+	{ErrNoOwners, "ErrNoOwners"},
+	{ErrInvalidFieldPath, "ErrInvalidFieldPath"},
+	{ErrFieldPathNotAllowed, "ErrFieldPathNotAllowed"},
+	{ErrBreakerOpen, "ErrBreakerOpen"},
+	{ErrNamespaceUnavailable, "ErrNamespaceUnavailable"},
+	{ErrContainerNotFound, "ErrContainerNotFound"},
+	{ErrUnknownProfile, "ErrUnknownProfile"},
+	{ErrInvalidPatch, "ErrInvalidPatch"},
+}
+
+// errorLabel normalises err to the name of the sentinel error it wraps or "internal".
+func errorLabel(err error) string {
+	for _, s := range sentinelErrors {
+		if errors.Is(err, s.err) {
+			return s.name
+		}
+	}
+	return "internal"
+}
+
+type routeKey struct{}
+
+// instrument records metrics for h against the registered path rather than the request path to bound cardinality,
+// h can retrieve the path with route.
+func instrument(path string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		g := inflight.WithLabelValues(path)
+		g.Inc()
+		defer g.Dec()
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), routeKey{}, path)))
+	})
+}
+
+// route returns the registered path serving r, "unknown" when r wasn't routed by instrument. Subtree routes match
+// many request paths so metrics and annotations use it rather than r.URL.Path.
+func route(r *http.Request) string {
+	path, ok := r.Context().Value(routeKey{}).(string)
+	if !ok {
+		return "unknown"
+	}
+	return path
+}
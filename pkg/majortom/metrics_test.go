@@ -0,0 +1,221 @@
+package majortom
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	v1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func Test_inflight_gauge_during_request(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	blocking := func(*corev1.Pod) ([]operation, error) {
+		close(started)
+		<-release
+		return nil, nil
+	}
+	rt := NewRouter()
+	rt.Handle("/blocking", blocking)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		r := post(&v1.AdmissionReview{Request: &v1.AdmissionRequest{Namespace: "default", Resource: resourcePods, Object: tidePod()}})
+		r.URL.Path = "/blocking"
+		rt.mux.ServeHTTP(httptest.NewRecorder(), r)
+	}()
+
+	<-started
+	during := testutil.ToFloat64(inflight.WithLabelValues("/blocking"))
+	close(release)
+	<-done
+	after := testutil.ToFloat64(inflight.WithLabelValues("/blocking"))
+
+	if during != 1 {
+		t.Errorf("inflight during=%v, want 1", during)
+	}
+	if after != 0 {
+		t.Errorf("inflight after=%v, want 0", after)
+	}
+}
+
+func histogramCount(t *testing.T, h *prometheus.HistogramVec, labels ...string) uint64 {
+	var m dto.Metric
+	err := h.WithLabelValues(labels...).(prometheus.Histogram).Write(&m)
+	if err != nil {
+		t.Fatalf("Write err=%v, want nil", err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
+
+func Test_patch_compute_duration_observed_per_request(t *testing.T) {
+	rt := NewRouter()
+	rt.Handle("/compute/", AddOwner)
+	before := histogramCount(t, patchDuration, "/compute/")
+	r := post(&v1.AdmissionReview{Request: &v1.AdmissionRequest{Namespace: "default", Resource: resourcePods, Object: tidePod()}})
+	// subtree routes are labelled by the registered route rather than the request path.
+	r.URL.Path = "/compute/abc123"
+	rt.mux.ServeHTTP(httptest.NewRecorder(), r)
+	after := histogramCount(t, patchDuration, "/compute/")
+	if after-before != 1 {
+		t.Errorf("observations=%v, want 1", after-before)
+	}
+}
+
+func Test_patchable_errors_counted_by_kind(t *testing.T) {
+	before := testutil.ToFloat64(patchableErrors.WithLabelValues("/errors", "ErrPodHasOwnerLabel"))
+	r := post(&v1.AdmissionReview{Request: &v1.AdmissionRequest{Namespace: "default", Resource: resourcePods, Object: podWithOwnerLabel()}})
+	r.URL.Path = "/errors"
+	rt := NewRouter()
+	rt.Handle("/errors", AddOwner)
+	rt.mux.ServeHTTP(httptest.NewRecorder(), r)
+	after := testutil.ToFloat64(patchableErrors.WithLabelValues("/errors", "ErrPodHasOwnerLabel"))
+	if after-before != 1 {
+		t.Errorf("errors delta=%v, want 1", after-before)
+	}
+}
+
+func Test_errorLabel(t *testing.T) {
+	cases := map[string]struct {
+		err   error
+		label string
+	}{
+		"sentinel":         {ErrPodHasOwnerLabel, "ErrPodHasOwnerLabel"},
+		"wrapped sentinel": {fmt.Errorf("var NODEIP: %w", ErrInvalidFieldPath), "ErrInvalidFieldPath"},
+		"invalid patch":    {fmt.Errorf("%w: missing path", ErrInvalidPatch), "ErrInvalidPatch"},
+		"other":            {fmt.Errorf("boom"), "internal"},
+	}
+
+	for n, tc := range cases {
+		tc := tc
+		t.Run(n, func(t *testing.T) {
+			actual := errorLabel(tc.err)
+			if actual != tc.label {
+				t.Errorf("errorLabel=%v, want %v", actual, tc.label)
+			}
+		})
+	}
+}
+
+func Test_namespace_admissions(t *testing.T) {
+	NamespaceMetrics = true
+	NamespaceMetricsAllowlist = stringSet{"tide": true}
+	defer func() {
+		NamespaceMetrics = false
+		NamespaceMetricsAllowlist = stringSet{}
+	}()
+	cases := map[string]struct {
+		namespace string
+		series    string
+	}{
+		"listed":   {"tide", "tide"},
+		"unlisted": {"billing", "other"},
+	}
+
+	for n, tc := range cases {
+		tc := tc
+		t.Run(n, func(t *testing.T) {
+			path := "/namespaced/" + tc.namespace
+			counter := namespaceAdmissions.WithLabelValues(path, tc.series, DecisionPatched)
+			before := testutil.ToFloat64(counter)
+			_, err := reviewPod(path, &v1.AdmissionRequest{Namespace: tc.namespace, Resource: resourcePods, Object: tidePod()}, AddOwner, DefaultFailPolicy)
+			if err != nil {
+				t.Fatalf("err=%v, want nil", err)
+			}
+			actual := testutil.ToFloat64(counter) - before
+			if actual != 1 {
+				t.Errorf("majortom_namespace_admission_total{namespace=%q} increase=%v, want 1", tc.series, actual)
+			}
+		})
+	}
+}
+
+func Test_namespace_admissions_skip_unreviewed(t *testing.T) {
+	NamespaceMetrics = true
+	defer func() { NamespaceMetrics = false }()
+	_, _ = reviewPod("/namespaced/system", &v1.AdmissionRequest{Namespace: "kube-system", Resource: resourcePods, Object: tidePod()}, AddOwner, DefaultFailPolicy)
+	actual := testutil.ToFloat64(namespaceAdmissions.WithLabelValues("/namespaced/system", "other", DecisionSystemNamespace))
+	if actual != 0 {
+		t.Errorf("majortom_namespace_admission_total=%v, want 0", actual)
+	}
+}
+
+func Test_namespace_admissions_disabled(t *testing.T) {
+	_, _ = reviewPod("/namespaced/disabled", &v1.AdmissionRequest{Namespace: "tide", Resource: resourcePods, Object: tidePod()}, AddOwner, DefaultFailPolicy)
+	actual := testutil.ToFloat64(namespaceAdmissions.WithLabelValues("/namespaced/disabled", "other", DecisionPatched)) + testutil.ToFloat64(namespaceAdmissions.WithLabelValues("/namespaced/disabled", "tide", DecisionPatched))
+	if actual != 0 {
+		t.Errorf("majortom_namespace_admission_total=%v, want 0", actual)
+	}
+}
+
+func Test_invalid_patch_counted_when_verified(t *testing.T) {
+	VerifyPatches = true
+	defer func() { VerifyPatches = false }()
+	buf := captureLog(t)
+	invalid := func(*corev1.Pod) ([]operation, error) {
+		return []operation{addOp("/metadata/labels", map[string]string{"app": "web"}), replaceOp("/spec/missing/0", "x")}, nil
+	}
+	before := testutil.ToFloat64(invalidPatches.WithLabelValues("/invalid"))
+	r := post(&v1.AdmissionReview{Request: &v1.AdmissionRequest{UID: "abc123", Namespace: "default", Resource: resourcePods, Object: tidePod()}})
+	r.URL.Path = "/invalid"
+	w := httptest.NewRecorder()
+	rt := NewRouter()
+	rt.Handle("/invalid", invalid)
+	rt.mux.ServeHTTP(w, r)
+
+	after := testutil.ToFloat64(invalidPatches.WithLabelValues("/invalid"))
+	if after-before != 1 {
+		t.Errorf("invalid patches=%v, want 1", after-before)
+	}
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("w.Code=%v, want StatusInternalServerError", w.Code)
+	}
+	expected := `status=invalid-patch path=/invalid uid=abc123 op='{"op":"replace","path":"/spec/missing/0","value":"x"}'`
+	if !strings.Contains(buf.String(), expected) {
+		t.Errorf("log=%q, want containing %q", buf.String(), expected)
+	}
+}
+
+func Test_invalid_patch_uses_route_policy(t *testing.T) {
+	VerifyPatches = true
+	defer func() { VerifyPatches = false }()
+	invalid := func(*corev1.Pod) ([]operation, error) {
+		return []operation{replaceOp("/spec/missing/0", "x")}, nil
+	}
+	r := post(&v1.AdmissionReview{Request: &v1.AdmissionRequest{UID: "abc123", Namespace: "default", Resource: resourcePods, Object: tidePod()}})
+	w := httptest.NewRecorder()
+	podPatch(w, r, invalid, FailOpen)
+	if w.Code != http.StatusOK {
+		t.Fatalf("w.Code=%v, want StatusOK", w.Code)
+	}
+	resp := decodeResponse(t, w)
+	if !resp.Allowed || resp.Patch != nil {
+		t.Errorf("resp=%#v, want allowed without patch", resp)
+	}
+}
+
+func Test_valid_patch_not_counted_when_verified(t *testing.T) {
+	VerifyPatches = true
+	defer func() { VerifyPatches = false }()
+	before := testutil.ToFloat64(invalidPatches.WithLabelValues("/valid"))
+	r := post(&v1.AdmissionReview{Request: &v1.AdmissionRequest{Namespace: "default", Resource: resourcePods, Object: tidePod()}})
+	r.URL.Path = "/valid"
+	w := httptest.NewRecorder()
+	podPatch(w, r, AddOwner, DefaultFailPolicy)
+
+	after := testutil.ToFloat64(invalidPatches.WithLabelValues("/valid"))
+	if after != before {
+		t.Errorf("invalid patches=%v, want 0", after-before)
+	}
+	if w.Code != http.StatusOK {
+		t.Errorf("w.Code=%v, want StatusOK", w.Code)
+	}
+}
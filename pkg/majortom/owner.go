@@ -0,0 +1,131 @@
+package majortom
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// OwnerOverrideAnnotation lets a pod's creator name the owner used by OwnerPatch in place of the default.
+const OwnerOverrideAnnotation = "majortom.io/owner"
+
+// OwnersAnnotation holds a JSON array of owners when OwnersPatch is used in OwnerAnnotation mode.
+const OwnersAnnotation = "majortom.io/owners"
+
+// OwnerSeparator joins multiple owners into a single label value, commas are not valid label characters.
+const OwnerSeparator = "_"
+
+type OwnerMode int
+
+const (
+	// OwnerLabel joins the owners into the owner label.
+	OwnerLabel OwnerMode = iota
+	// OwnerAnnotation writes the owners as a JSON array to the OwnersAnnotation.
+	OwnerAnnotation
+)
+
+var ErrPodHasOwnersAnnotation = fmt.Errorf("pod has owners annotation")
+
+var ErrNoOwners = fmt.Errorf("no owners specified")
+
+// OwnersPatch records one or more owners against the pod as either a label or an annotation.
+func OwnersPatch(mode OwnerMode, owners []string) PodPatchable {
+	return func(pod *corev1.Pod) ([]operation, error) {
+		if len(owners) == 0 {
+			return nil, ErrNoOwners
+		}
+
+		if mode == OwnerAnnotation {
+			_, ok := pod.ObjectMeta.Annotations[OwnersAnnotation]
+			if ok {
+				return nil, ErrPodHasOwnersAnnotation
+			}
+			b, err := json.Marshal(owners)
+			if err != nil {
+				return nil, err
+			}
+			return mapEntriesOps(annotationsPath, pod.ObjectMeta.Annotations, map[string]string{OwnersAnnotation: string(b)}), nil
+		}
+
+		_, ok := pod.ObjectMeta.Labels["owner"]
+		if ok {
+			return nil, ErrPodHasOwnerLabel
+		}
+		for _, owner := range owners {
+			errs := validation.IsValidLabelValue(owner)
+			if len(errs) > 0 {
+				return nil, fmt.Errorf("invalid owner %q: %s", owner, strings.Join(errs, "; "))
+			}
+		}
+		value := strings.Join(owners, OwnerSeparator)
+		errs := validation.IsValidLabelValue(value)
+		if len(errs) > 0 {
+			return nil, fmt.Errorf("invalid owners %q: %s", value, strings.Join(errs, "; "))
+		}
+		return mapEntriesOps(labelsPath, pod.ObjectMeta.Labels, map[string]string{"owner": value}), nil
+	}
+}
+
+// OwnerOption configures OwnerPatch.
+type OwnerOption func(*ownerOptions)
+
+type ownerOptions struct {
+	identityLabels []string
+	enforce        bool
+}
+
+// SkipWithLabels skips pods carrying any of the identity labels (e.g. team, app.kubernetes.io/name) as they're
+// already attributed.
+func SkipWithLabels(keys ...string) OwnerOption {
+	return func(cfg *ownerOptions) {
+		cfg.identityLabels = append(cfg.identityLabels, keys...)
+	}
+}
+
+// EnforceOwner replaces an existing owner label that differs from the resolved owner, the OwnerOverrideAnnotation
+// falling back to the default owner, instead of failing with ErrPodHasOwnerLabel. Pods already labelled with the
+// resolved owner are left unmodified.
+func EnforceOwner() OwnerOption {
+	return func(cfg *ownerOptions) {
+		cfg.enforce = true
+	}
+}
+
+// OwnerPatch labels the pod with the owner named by the OwnerOverrideAnnotation falling back to defaultOwner.
+func OwnerPatch(defaultOwner string, opts ...OwnerOption) PodPatchable {
+	cfg := &ownerOptions{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return func(pod *corev1.Pod) ([]operation, error) {
+		owner := defaultOwner
+		override, ok := pod.ObjectMeta.Annotations[OwnerOverrideAnnotation]
+		if ok {
+			errs := validation.IsValidLabelValue(override)
+			if len(errs) > 0 {
+				return nil, fmt.Errorf("invalid %s annotation %q: %s", OwnerOverrideAnnotation, override, strings.Join(errs, "; "))
+			}
+			owner = override
+		}
+		existing, ok := pod.ObjectMeta.Labels["owner"]
+		if ok && cfg.enforce {
+			if existing == owner {
+				return nil, nil
+			}
+			return []operation{replaceOp(labelsPath+"/owner", owner)}, nil
+		}
+		if ok {
+			return nil, ErrPodHasOwnerLabel
+		}
+		for _, key := range cfg.identityLabels {
+			_, ok := pod.ObjectMeta.Labels[key]
+			if ok {
+				return nil, nil
+			}
+		}
+		return mapEntriesOps(labelsPath, pod.ObjectMeta.Labels, map[string]string{"owner": owner}), nil
+	}
+}
@@ -0,0 +1,187 @@
+package majortom
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_OwnersPatch(t *testing.T) {
+	cases := map[string]struct {
+		mode       OwnerMode
+		owners     []string
+		label      string
+		annotation string
+	}{
+		"single label":         {OwnerLabel, []string{"nathan.fisher"}, "nathan.fisher", ""},
+		"multiple labels":      {OwnerLabel, []string{"nathan.fisher", "betty.boop"}, "nathan.fisher_betty.boop", ""},
+		"single annotation":    {OwnerAnnotation, []string{"nathan.fisher"}, "", `["nathan.fisher"]`},
+		"multiple annotations": {OwnerAnnotation, []string{"nathan.fisher", "betty.boop"}, "", `["nathan.fisher","betty.boop"]`},
+	}
+
+	for n, tc := range cases {
+		tc := tc
+		t.Run(n, func(t *testing.T) {
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "nginx"}}}
+			ops, err := OwnersPatch(tc.mode, tc.owners)(pod)
+			if err != nil {
+				t.Fatalf("err=%v, want nil", err)
+			}
+			patched := applyPatch(t, pod, ops)
+			if patched.Labels["owner"] != tc.label {
+				t.Errorf("labels[owner]=%v, want %v", patched.Labels["owner"], tc.label)
+			}
+			if patched.Annotations[OwnersAnnotation] != tc.annotation {
+				t.Errorf("annotations[%s]=%v, want %v", OwnersAnnotation, patched.Annotations[OwnersAnnotation], tc.annotation)
+			}
+			if patched.Labels["app"] != "nginx" {
+				t.Errorf("labels[app]=%v, want nginx", patched.Labels["app"])
+			}
+		})
+	}
+}
+
+func Test_OwnersPatch_errors(t *testing.T) {
+	cases := map[string]struct {
+		mode   OwnerMode
+		owners []string
+		pod    *corev1.Pod
+	}{
+		"no owners":           {OwnerLabel, nil, &corev1.Pod{}},
+		"invalid label value": {OwnerLabel, []string{"nathan fisher"}, &corev1.Pod{}},
+		"existing label":      {OwnerLabel, []string{"nathan.fisher"}, &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"owner": "betty.boop"}}}},
+		"existing annotation": {OwnerAnnotation, []string{"nathan.fisher"}, &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{OwnersAnnotation: "[]"}}}},
+	}
+
+	for n, tc := range cases {
+		tc := tc
+		t.Run(n, func(t *testing.T) {
+			_, err := OwnersPatch(tc.mode, tc.owners)(tc.pod)
+			if err == nil {
+				t.Error("err=nil, want error")
+			}
+		})
+	}
+}
+
+func Test_OwnersPatch_annotation_without_annotations(t *testing.T) {
+	pod := &corev1.Pod{}
+	ops, err := OwnersPatch(OwnerAnnotation, []string{"nathan.fisher"})(pod)
+	if err != nil {
+		t.Fatalf("err=%v, want nil", err)
+	}
+	if len(ops) != 1 || ops[0].Path != "/metadata/annotations" {
+		t.Errorf("ops=%#v, want single add at /metadata/annotations", ops)
+	}
+}
+
+func Test_escapePointer(t *testing.T) {
+	actual := escapePointer("majortom.io/owners~x")
+	expected := "majortom.io~1owners~0x"
+	if actual != expected {
+		t.Errorf("escapePointer()=%v, want %v", actual, expected)
+	}
+}
+
+func Test_OwnerPatch(t *testing.T) {
+	cases := map[string]struct {
+		annotations map[string]string
+		owner       string
+	}{
+		"annotation absent":  {nil, "nathan.fisher"},
+		"annotation present": {map[string]string{OwnerOverrideAnnotation: "alice"}, "alice"},
+	}
+
+	for n, tc := range cases {
+		tc := tc
+		t.Run(n, func(t *testing.T) {
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: tc.annotations}}
+			ops, err := OwnerPatch("nathan.fisher")(pod)
+			if err != nil {
+				t.Fatalf("err=%v, want nil", err)
+			}
+			patched := applyPatch(t, pod, ops)
+			if patched.Labels["owner"] != tc.owner {
+				t.Errorf("labels[owner]=%v, want %v", patched.Labels["owner"], tc.owner)
+			}
+		})
+	}
+}
+
+func Test_OwnerPatch_invalid_annotation(t *testing.T) {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{OwnerOverrideAnnotation: "alice smith"}}}
+	_, err := OwnerPatch("nathan.fisher")(pod)
+	if err == nil {
+		t.Error("err=nil, want error")
+	}
+}
+
+func Test_OwnerPatch_SkipWithLabels(t *testing.T) {
+	cases := map[string]struct {
+		labels map[string]string
+		ops    int
+	}{
+		"identity label": {map[string]string{"app.kubernetes.io/name": "tide"}, 0},
+		"other label":    {map[string]string{"tier": "web"}, 1},
+		"no labels":      {nil, 1},
+	}
+
+	for n, tc := range cases {
+		tc := tc
+		t.Run(n, func(t *testing.T) {
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Labels: tc.labels}}
+			ops, err := OwnerPatch("nathan.fisher", SkipWithLabels("team", "app.kubernetes.io/name"))(pod)
+			if err != nil {
+				t.Fatalf("err=%v, want nil", err)
+			}
+			if len(ops) != tc.ops {
+				t.Errorf("len(ops)=%v, want %v", len(ops), tc.ops)
+			}
+			patched := applyPatch(t, pod, ops)
+			_, owned := patched.Labels["owner"]
+			if owned != (tc.ops > 0) {
+				t.Errorf("labels=%v, want owner %v", patched.Labels, tc.ops > 0)
+			}
+		})
+	}
+}
+
+func Test_OwnerPatch_EnforceOwner(t *testing.T) {
+	cases := map[string]struct {
+		labels      map[string]string
+		annotations map[string]string
+		ops         []string
+		owner       string
+	}{
+		"matching":             {map[string]string{"owner": "nathan.fisher"}, nil, nil, "nathan.fisher"},
+		"differing":            {map[string]string{"owner": "betty.boop"}, nil, []string{"replace"}, "nathan.fisher"},
+		"absent":               {map[string]string{"app": "tide"}, nil, []string{"add"}, "nathan.fisher"},
+		"matching override":    {map[string]string{"owner": "alice"}, map[string]string{OwnerOverrideAnnotation: "alice"}, nil, "alice"},
+		"differing override":   {map[string]string{"owner": "nathan.fisher"}, map[string]string{OwnerOverrideAnnotation: "alice"}, []string{"replace"}, "alice"},
+		"absent with override": {map[string]string{"app": "tide"}, map[string]string{OwnerOverrideAnnotation: "alice"}, []string{"add"}, "alice"},
+	}
+
+	for n, tc := range cases {
+		tc := tc
+		t.Run(n, func(t *testing.T) {
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Labels: tc.labels, Annotations: tc.annotations}}
+			ops, err := OwnerPatch("nathan.fisher", EnforceOwner())(pod)
+			if err != nil {
+				t.Fatalf("err=%v, want nil", err)
+			}
+			var actual []string
+			for _, op := range ops {
+				actual = append(actual, op.Op)
+			}
+			if !cmp.Equal(actual, tc.ops) {
+				t.Errorf("ops mismatch (+want -got)\n%s", cmp.Diff(actual, tc.ops))
+			}
+			patched := applyPatch(t, pod, ops)
+			if patched.Labels["owner"] != tc.owner {
+				t.Errorf("labels[owner]=%v, want %v", patched.Labels["owner"], tc.owner)
+			}
+		})
+	}
+}
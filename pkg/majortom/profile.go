@@ -0,0 +1,28 @@
+package majortom
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// ProfileLabel names the profile a pod selects, e.g. majortom.io/profile: web.
+const ProfileLabel = "majortom.io/profile"
+
+var ErrUnknownProfile = fmt.Errorf("unknown profile")
+
+// ProfilePatch applies the patchable of the profile selected by the pod's ProfileLabel. Pods without the label are
+// left as-is and those selecting a profile that doesn't exist fail with ErrUnknownProfile so the typo is surfaced.
+func ProfilePatch(profiles map[string]PodPatchable) PodPatchable {
+	return func(pod *corev1.Pod) ([]operation, error) {
+		name, ok := pod.ObjectMeta.Labels[ProfileLabel]
+		if !ok {
+			return nil, nil
+		}
+		p, ok := profiles[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownProfile, name)
+		}
+		return p(pod)
+	}
+}
@@ -0,0 +1,41 @@
+package majortom
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// TrustedProxies are the CIDRs of proxies whose X-Forwarded-For header is believed, empty ignores the header.
+var TrustedProxies cidrList
+
+// clientAddr is the address of the request's source. When the peer is a trusted proxy the X-Forwarded-For entries
+// are walked from the nearest hop and the first untrusted address is the source, entries added before that can be
+// forged by the client so aren't considered.
+func clientAddr(r *http.Request) string {
+	addr, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		addr = r.RemoteAddr
+	}
+	if len(TrustedProxies) == 0 || !TrustedProxies.contains(net.ParseIP(addr)) {
+		return addr
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		ip := net.ParseIP(hop)
+		if ip == nil {
+			LevelDebug.Printf("status=ignored remote=%s err='invalid X-Forwarded-For entry %q'", addr, hop)
+			return addr
+		}
+		addr = hop
+		if !TrustedProxies.contains(ip) {
+			return addr
+		}
+	}
+	return addr
+}
@@ -0,0 +1,53 @@
+package majortom
+
+import (
+	"net/http"
+	"testing"
+)
+
+func Test_clientAddr(t *testing.T) {
+	cases := map[string]struct {
+		trusted    string
+		remoteAddr string
+		xff        []string
+		want       string
+	}{
+		"no proxies":            {"", "10.0.0.5:40000", []string{"192.0.2.10"}, "10.0.0.5"},
+		"untrusted peer":        {"10.1.0.0/16", "10.0.0.5:40000", []string{"192.0.2.10"}, "10.0.0.5"},
+		"trusted without xff":   {"10.0.0.0/16", "10.0.0.5:40000", nil, "10.0.0.5"},
+		"trusted proxy":         {"10.0.0.0/16", "10.0.0.5:40000", []string{"192.0.2.10"}, "192.0.2.10"},
+		"forged leftmost entry": {"10.0.0.0/16", "10.0.0.5:40000", []string{"203.0.113.1, 192.0.2.10"}, "192.0.2.10"},
+		"chained proxies":       {"10.0.0.0/16", "10.0.0.5:40000", []string{"192.0.2.10", "10.0.3.1"}, "192.0.2.10"},
+		"all trusted":           {"10.0.0.0/16", "10.0.0.5:40000", []string{"10.0.3.1"}, "10.0.3.1"},
+		"invalid entry":         {"10.0.0.0/16", "10.0.0.5:40000", []string{"unknown"}, "10.0.0.5"},
+	}
+
+	for n, tc := range cases {
+		tc := tc
+		t.Run(n, func(t *testing.T) {
+			TrustedProxies = nil
+			defer func() { TrustedProxies = nil }()
+			err := TrustedProxies.Set(tc.trusted)
+			if err != nil {
+				t.Fatalf("Set err=%v, want nil", err)
+			}
+			r, _ := http.NewRequest(http.MethodPost, "/", nil)
+			r.RemoteAddr = tc.remoteAddr
+			for _, xff := range tc.xff {
+				r.Header.Add("X-Forwarded-For", xff)
+			}
+			actual := clientAddr(r)
+			if actual != tc.want {
+				t.Errorf("clientAddr()=%v, want %v", actual, tc.want)
+			}
+		})
+	}
+}
+
+func Test_cidrList_Set_invalid(t *testing.T) {
+	var c cidrList
+	err := c.Set("10.0.0.0/33")
+	if err == nil {
+		t.Error("err=nil, want error")
+	}
+}
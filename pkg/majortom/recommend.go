@@ -0,0 +1,43 @@
+package majortom
+
+import (
+	"net/http"
+
+	v1 "k8s.io/api/admission/v1"
+)
+
+// Recommend is Review for routes that nudge rather than force. The patch is still applied but the requester is
+// warned with warning so teams can adopt the change in their manifests.
+func Recommend(req *v1.AdmissionRequest, apply PodPatchable, warning string) (*v1.AdmissionResponse, error) {
+	return recommendPod("", req, apply, DefaultFailPolicy, warning)
+}
+
+// recommendPod is Recommend for the route on path failing under the route's policy.
+func recommendPod(path string, req *v1.AdmissionRequest, apply PodPatchable, policy FailPolicy, warning string) (*v1.AdmissionResponse, error) {
+	resp, err := reviewPod(path, req, apply, policy)
+	if err != nil {
+		return nil, err
+	}
+	// only pods that didn't already match the recommendation are warned.
+	if resp.Allowed && len(resp.Patch) > 0 {
+		LevelInfo.Printf("status=recommended path=%s uid=%s", path, req.UID)
+		resp.Warnings = append(resp.Warnings, warning)
+	}
+	return resp, nil
+}
+
+func bindRecommend(p PodPatchable, warning string, policy func() FailPolicy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		review, ok := readRequest(w, r)
+		if !ok {
+			return
+		}
+
+		resp, err := recommendPod(route(r), review.Request, p, policy(), warning)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeReview(w, r, resp)
+	}
+}
@@ -0,0 +1,55 @@
+package majortom
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	v1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const recommendation = "pods should set the owner label, majortom will stop adding it"
+
+func Test_Recommend_returns_patch_and_warning(t *testing.T) {
+	resp, err := Recommend(&v1.AdmissionRequest{UID: "abc123", Namespace: "default", Resource: resourcePods, Object: tidePod()}, AddOwner, recommendation)
+	if err != nil {
+		t.Fatalf("err=%v, want nil", err)
+	}
+	if !resp.Allowed {
+		t.Error("resp.Allowed=false, want true")
+	}
+	if resp.Patch == nil {
+		t.Error("resp.Patch=nil, want patch")
+	}
+	if len(resp.Warnings) != 1 || resp.Warnings[0] != recommendation {
+		t.Errorf("resp.Warnings=%v, want [%v]", resp.Warnings, recommendation)
+	}
+}
+
+func Test_Recommend_skips_warning_without_patch(t *testing.T) {
+	noop := func(*corev1.Pod) ([]operation, error) { return nil, nil }
+	resp, err := Recommend(&v1.AdmissionRequest{UID: "abc123", Namespace: "default", Resource: resourcePods, Object: tidePod()}, noop, recommendation)
+	if err != nil {
+		t.Fatalf("err=%v, want nil", err)
+	}
+	if len(resp.Warnings) != 0 {
+		t.Errorf("resp.Warnings=%v, want none", resp.Warnings)
+	}
+}
+
+func Test_HandleRecommend(t *testing.T) {
+	rt := NewRouter()
+	rt.HandleRecommend("/recommend/owner", AddOwner, recommendation)
+	r := post(&v1.AdmissionReview{Request: &v1.AdmissionRequest{UID: "abc123", Namespace: "default", Resource: resourcePods, Object: tidePod()}})
+	r.URL.Path = "/recommend/owner"
+	w := httptest.NewRecorder()
+	rt.mux.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("w.Code=%v, want StatusOK", w.Code)
+	}
+	resp := decodeResponse(t, w)
+	if !resp.Allowed || resp.Patch == nil || len(resp.Warnings) != 1 {
+		t.Errorf("resp=%#v, want allowed with patch and a warning", resp)
+	}
+}
@@ -0,0 +1,101 @@
+package majortom
+
+import (
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Reloader serves the most recently loaded Router. A failed reload keeps serving the previous Router, after
+// Threshold consecutive failures Livez reports unhealthy so Kubernetes restarts the pod with a fresh config.
+type Reloader struct {
+	Threshold int
+
+	load     func() (*Router, error)
+	mu       sync.RWMutex
+	current  *Router
+	failures int
+}
+
+// NewReloader returns a Reloader with the initial Router from load.
+func NewReloader(load func() (*Router, error), threshold int) (*Reloader, error) {
+	rt, err := load()
+	if err != nil {
+		return nil, err
+	}
+	return &Reloader{Threshold: threshold, load: load, current: rt}, nil
+}
+
+// Reload replaces the current Router, on failure the current Router is retained.
+func (rl *Reloader) Reload() error {
+	rt, err := rl.load()
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if err != nil {
+		rl.failures++
+		LevelError.Printf("status=reload-failed failures=%d err='%v'", rl.failures, err)
+		return err
+	}
+	rl.current = rt
+	rl.failures = 0
+	LevelInfo.Printf("status=reloaded routes=%d", len(rt.routes))
+	return nil
+}
+
+// Watch reloads every interval until stop is closed.
+func (rl *Reloader) Watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			_ = rl.Reload()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// WatchSignal reloads each time a signal is received on sig until stop is closed, letting operators trigger a
+// reload with SIGHUP instead of polling with Watch.
+func (rl *Reloader) WatchSignal(sig <-chan os.Signal, stop <-chan struct{}) {
+	for {
+		select {
+		case s := <-sig:
+			LevelInfo.Printf("status=reloading signal=%s", s)
+			_ = rl.Reload()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Router returns the current Router.
+func (rl *Reloader) Router() *Router {
+	rl.mu.RLock()
+	defer rl.mu.RUnlock()
+	return rl.current
+}
+
+func (rl *Reloader) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rl.Router().mux.ServeHTTP(w, r)
+}
+
+// Healthy reports whether consecutive reload failures are below the Threshold.
+func (rl *Reloader) Healthy() bool {
+	rl.mu.RLock()
+	defer rl.mu.RUnlock()
+	return rl.Threshold <= 0 || rl.failures < rl.Threshold
+}
+
+// Livez returns a liveness handler that fails once reloads have repeatedly failed.
+func (rl *Reloader) Livez() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !rl.Healthy() {
+			http.Error(w, "config reload failing", http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("ok\n"))
+	})
+}
@@ -0,0 +1,105 @@
+package majortom
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func livez(rl *Reloader) int {
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest(http.MethodGet, "/livez", nil)
+	rl.Livez().ServeHTTP(w, r)
+	return w.Code
+}
+
+func Test_Reloader_livez_fails_after_repeated_failures(t *testing.T) {
+	var err error
+	load := func() (*Router, error) {
+		return NewRouter(), err
+	}
+	rl, _ := NewReloader(load, 3)
+
+	err = fmt.Errorf("config invalid")
+	for i := 0; i < 2; i++ {
+		_ = rl.Reload()
+		if code := livez(rl); code != http.StatusOK {
+			t.Errorf("livez after %d failures=%v, want StatusOK", i+1, code)
+		}
+	}
+	_ = rl.Reload()
+	if code := livez(rl); code != http.StatusServiceUnavailable {
+		t.Errorf("livez after 3 failures=%v, want StatusServiceUnavailable", code)
+	}
+
+	err = nil
+	_ = rl.Reload()
+	if code := livez(rl); code != http.StatusOK {
+		t.Errorf("livez after success=%v, want StatusOK", code)
+	}
+}
+
+func Test_Reloader_keeps_router_on_failure(t *testing.T) {
+	initial := NewRouter()
+	initial.Handle("/labels/owner", AddOwner)
+	calls := 0
+	load := func() (*Router, error) {
+		calls++
+		if calls > 1 {
+			return nil, fmt.Errorf("config invalid")
+		}
+		return initial, nil
+	}
+	rl, _ := NewReloader(load, 3)
+	err := rl.Reload()
+	if err == nil {
+		t.Error("Reload err=nil, want error")
+	}
+	if rl.Router() != initial {
+		t.Error("rl.Router() replaced after failed reload")
+	}
+}
+
+func Test_NewReloader_initial_failure(t *testing.T) {
+	_, err := NewReloader(func() (*Router, error) { return nil, fmt.Errorf("missing") }, 3)
+	if err == nil {
+		t.Error("err=nil, want error")
+	}
+}
+
+func Test_Reloader_WatchSignal(t *testing.T) {
+	path := writeConfig(t, tempDir(t), "majortom.yaml", ownerConfig)
+	ConfigPath = path
+	defer func() { ConfigPath = "" }()
+	rl, err := NewReloader(loadRouter, 3)
+	if err != nil {
+		t.Fatalf("NewReloader err=%v, want nil", err)
+	}
+	hup := make(chan os.Signal)
+	stop := make(chan struct{})
+	defer close(stop)
+	go rl.WatchSignal(hup, stop)
+
+	// the unbuffered second send completes only once the first reload has returned.
+	reload := func() {
+		hup <- syscall.SIGHUP
+		hup <- syscall.SIGHUP
+	}
+
+	writeConfig(t, filepath.Dir(path), "majortom.yaml", "routes:\n  - path: /labels/team\n    labels:\n      team: platform")
+	reload()
+	reloaded := rl.Router()
+	if reloaded.Summary() != "/labels/team:mutate[labels]" {
+		t.Errorf("summary()=%v, want /labels/team:mutate[labels]", reloaded.Summary())
+	}
+
+	writeConfig(t, filepath.Dir(path), "majortom.yaml", "routes:\n  - path: /labels/team\n    labels:\n      team: bad value")
+	reload()
+	if rl.Router() != reloaded {
+		t.Error("rl.Router() replaced after invalid config")
+	}
+}
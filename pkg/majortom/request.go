@@ -0,0 +1,113 @@
+package majortom
+
+import (
+	"net/http"
+	"sync"
+
+	v1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// RequestPatchable is a PodPatchable that also considers the admission request, e.g. the requesting user.
+type RequestPatchable func(*v1.AdmissionRequest, *corev1.Pod) ([]operation, error)
+
+// patchable binds rp to req.
+func (rp RequestPatchable) patchable(req *v1.AdmissionRequest) PodPatchable {
+	return func(pod *corev1.Pod) ([]operation, error) {
+		return rp(req, pod)
+	}
+}
+
+// WhenUserGroups delegates to p only when the requesting user belongs to one of groups (e.g.
+// system:serviceaccounts:ci).
+func WhenUserGroups(groups []string, p PodPatchable) RequestPatchable {
+	set := map[string]bool{}
+	for _, group := range groups {
+		set[group] = true
+	}
+	return func(req *v1.AdmissionRequest, pod *corev1.Pod) ([]operation, error) {
+		for _, group := range req.UserInfo.Groups {
+			if set[group] {
+				return p(pod)
+			}
+		}
+		return nil, nil
+	}
+}
+
+func bindRequest(rp RequestPatchable, policy func() FailPolicy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		review, ok := readRequest(w, r)
+		if !ok {
+			return
+		}
+
+		resp, err := reviewPod(route(r), review.Request, rp.patchable(review.Request), policy())
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeReview(w, r, resp)
+	}
+}
+
+// OncePerNamespace delegates to p only for the first pod admitted in each namespace, e.g. to stamp a bootstrap
+// annotation. Namespaces are taken from the request as the pod's namespace may be unset on CREATE. Dry runs and pods
+// that aren't admitted, because p or a guard such as MaxOps failed, don't mark a namespace as seen.
+//
+// The seen namespaces are held in memory so they're forgotten on restart and each replica tracks its own, with
+// multiple replicas or concurrent first pods p may run more than once per namespace. Patchables must tolerate running
+// more than once.
+func OncePerNamespace(p PodPatchable) RequestPatchable {
+	var mu sync.Mutex
+	seen := map[string]bool{}
+	return func(req *v1.AdmissionRequest, pod *corev1.Pod) ([]operation, error) {
+		mu.Lock()
+		done := seen[req.Namespace]
+		mu.Unlock()
+		if done {
+			return nil, nil
+		}
+		ops, err := p(pod)
+		if err != nil {
+			return nil, err
+		}
+		if req.DryRun == nil || !*req.DryRun {
+			namespace := req.Namespace
+			onAdmit(req, func() {
+				mu.Lock()
+				seen[namespace] = true
+				mu.Unlock()
+			})
+		}
+		return ops, nil
+	}
+}
+
+var (
+	admitMu sync.Mutex
+	// admitHooks are run by settle once the response to their request admitted the pod.
+	admitHooks = map[*v1.AdmissionRequest][]func(){}
+)
+
+// onAdmit defers f until req's pod is admitted, patchables use it for side effects that must not happen when the
+// review later fails.
+func onAdmit(req *v1.AdmissionRequest, f func()) {
+	admitMu.Lock()
+	defer admitMu.Unlock()
+	admitHooks[req] = append(admitHooks[req], f)
+}
+
+// settle runs the hooks deferred by onAdmit for req when it was admitted and forgets them either way.
+func settle(req *v1.AdmissionRequest, admitted bool) {
+	admitMu.Lock()
+	hooks := admitHooks[req]
+	delete(admitHooks, req)
+	admitMu.Unlock()
+	if !admitted {
+		return
+	}
+	for _, f := range hooks {
+		f()
+	}
+}
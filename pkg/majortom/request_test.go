@@ -0,0 +1,82 @@
+package majortom
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	v1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+)
+
+func Test_WhenUserGroups(t *testing.T) {
+	cases := map[string]struct {
+		groups []string
+		patch  bool
+	}{
+		"ci service account": {[]string{"system:serviceaccounts", "system:serviceaccounts:ci", "system:authenticated"}, true},
+		"other user":         {[]string{"system:masters", "system:authenticated"}, false},
+		"no groups":          {nil, false},
+	}
+
+	for n, tc := range cases {
+		tc := tc
+		t.Run(n, func(t *testing.T) {
+			rt := NewRouter()
+			rt.HandleRequest("/ci", WhenUserGroups([]string{"system:serviceaccounts:ci"}, AddOwner))
+			r := post(&v1.AdmissionReview{Request: &v1.AdmissionRequest{
+				UID:       "abc123",
+				Namespace: "default",
+				Resource:  resourcePods,
+				UserInfo:  authenticationv1.UserInfo{Username: "system:serviceaccount:ci:deployer", Groups: tc.groups},
+				Object:    tidePod(),
+			}})
+			r.URL.Path = "/ci"
+			w := httptest.NewRecorder()
+			rt.mux.ServeHTTP(w, r)
+			if w.Code != http.StatusOK {
+				t.Fatalf("w.Code=%v, want StatusOK", w.Code)
+			}
+			resp := decodeResponse(t, w)
+			if !resp.Allowed {
+				t.Error("resp.Allowed=false, want true")
+			}
+			actual := resp.Patch != nil
+			if actual != tc.patch {
+				t.Errorf("patched=%v, want %v", actual, tc.patch)
+			}
+		})
+	}
+}
+
+func Test_OncePerNamespace(t *testing.T) {
+	defer func() { MaxOps = 100 }()
+	bootstrap := OncePerNamespace(AnnotationsPatch(map[string]string{"majortom.io/bootstrap": "true"}))
+	dryRun := true
+	cases := []struct {
+		name      string
+		namespace string
+		dryRun    *bool
+		maxOps    int
+		patched   bool
+	}{
+		{"dry run first pod", "payments", &dryRun, 100, true},
+		{"too many ops", "payments", nil, 0, false},
+		{"first pod", "payments", nil, 100, true},
+		{"second pod", "payments", nil, 100, false},
+		{"first pod other namespace", "checkout", nil, 100, true},
+	}
+
+	for _, tc := range cases {
+		MaxOps = tc.maxOps
+		req := &v1.AdmissionRequest{Namespace: tc.namespace, DryRun: tc.dryRun, Resource: resourcePods, Object: tidePod()}
+		resp, _ := Review(req, bootstrap.patchable(req))
+		patched := resp != nil && len(resp.Patch) > 0
+		if patched != tc.patched {
+			t.Errorf("%s: patched=%v, want %v", tc.name, patched, tc.patched)
+		}
+	}
+	if len(admitHooks) != 0 {
+		t.Errorf("len(admitHooks)=%v, want 0", len(admitHooks))
+	}
+}
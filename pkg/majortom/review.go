@@ -0,0 +1,254 @@
+package majortom
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	v1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// ReviewError is returned by Review when no admission response should be sent, HTTP transports respond with Code
+// which leaves the decision to the webhook configuration's failurePolicy.
+type ReviewError struct {
+	Code    int
+	Message string
+}
+
+func (e *ReviewError) Error() string {
+	return e.Message
+}
+
+// Review decides the admission response for req applying the patchable to the pod under the DefaultFailPolicy. It is
+// the transport independent core of the mutating webhook.
+func Review(req *v1.AdmissionRequest, apply PodPatchable) (*v1.AdmissionResponse, error) {
+	return reviewPod("", req, apply, DefaultFailPolicy)
+}
+
+// reviewPod is Review for the route on path, used for logging and metrics, failing under the route's policy.
+func reviewPod(path string, req *v1.AdmissionRequest, apply PodPatchable, policy FailPolicy) (*v1.AdmissionResponse, error) {
+	return reviewSubresource(path, req, "", apply, policy)
+}
+
+// reviewSubresource is reviewPod for routes handling requests to subresource, all other requests are allowed
+// unmodified.
+func reviewSubresource(path string, req *v1.AdmissionRequest, subresource string, apply PodPatchable, policy FailPolicy) (*v1.AdmissionResponse, error) {
+	// decoding the options is only worth it when they're logged.
+	if LevelDebug.Enabled() {
+		LevelDebug.Printf("status=reviewing path=%s uid=%s operation=%s field-manager=%s dry-run=%v", path, req.UID, req.Operation, FieldManager(req), req.DryRun != nil && *req.DryRun)
+	}
+	pod, resp, err := admitSubresource(path, req, subresource)
+	if resp != nil || err != nil {
+		return resp, err
+	}
+	return patchPod(path, req, pod, subresource, apply, policy)
+}
+
+// patchPod responds with the patch apply produces for an admitted pod, failures are handled by policy unless apply
+// failed under a WithFailPolicy of its own.
+func patchPod(path string, req *v1.AdmissionRequest, pod *corev1.Pod, subresource string, apply PodPatchable, policy FailPolicy) (*v1.AdmissionResponse, error) {
+	admitted := false
+	defer func() { settle(req, admitted) }()
+
+	start := time.Now()
+	ops, err := apply(pod)
+	patchDuration.WithLabelValues(path).Observe(time.Since(start).Seconds())
+	if err != nil {
+		patchableErrors.WithLabelValues(path, errorLabel(err)).Inc()
+		audit(path, req, DecisionFailed)
+		countNamespace(path, req.Namespace, DecisionFailed)
+		return failPolicy(err, policy).fail(path, req, http.StatusForbidden, err)
+	}
+
+	// only stamp pods we otherwise modified, subresource updates may only change their own fields.
+	if len(ops) > 0 && subresource == "" {
+		ops = append(ops, stampOps(pod, ops, path)...)
+	}
+
+	// checked after stamping so the ops sent never exceed MaxOps.
+	if len(ops) > MaxOps {
+		audit(path, req, DecisionFailed)
+		countNamespace(path, req.Namespace, DecisionFailed)
+		return policy.fail(path, req, http.StatusInternalServerError, fmt.Errorf("%w: %d exceeds %d", ErrTooManyOps, len(ops), MaxOps))
+	}
+
+	if VerifyPatches && len(ops) > 0 {
+		op, err := verifyOps(pod, ops)
+		if err != nil {
+			invalidPatches.WithLabelValues(path).Inc()
+			LevelError.Printf("status=invalid-patch path=%s uid=%s op='%s' err='%v'", path, req.UID, op, err)
+			audit(path, req, DecisionFailed)
+			countNamespace(path, req.Namespace, DecisionFailed)
+			return policy.fail(path, req, http.StatusInternalServerError, fmt.Errorf("%w: %v", ErrInvalidPatch, err))
+		}
+	}
+
+	if req.Kind.Kind == EphemeralContainersKind {
+		ops = ephemeralContainersOps(ops)
+	}
+
+	resp := &v1.AdmissionResponse{
+		UID:     req.UID,
+		Allowed: true,
+	}
+
+	// an empty patch is allowed as-is, a JSON patch of `null` would be rejected by the apiserver.
+	if len(ops) > 0 {
+		patch, err := marshal(ops)
+		if err != nil {
+			LevelError.Printf("status=failed path=%s err='ops marshal: %v'", path, err)
+			return nil, &ReviewError{http.StatusInternalServerError, "unable to marshal operation json"}
+		}
+		if MaxPatchBytes > 0 && len(patch) > MaxPatchBytes {
+			audit(path, req, DecisionFailed)
+			countNamespace(path, req.Namespace, DecisionFailed)
+			return policy.fail(path, req, http.StatusInternalServerError, fmt.Errorf("%w: %d bytes exceeds %d", ErrPatchTooLarge, len(patch), MaxPatchBytes))
+		}
+		pt := v1.PatchTypeJSONPatch
+		resp.PatchType = &pt
+		resp.Patch = patch
+		audit(path, req, DecisionPatched)
+		countNamespace(path, req.Namespace, DecisionPatched)
+	} else {
+		audit(path, req, DecisionUnchanged)
+		countNamespace(path, req.Namespace, DecisionUnchanged)
+	}
+
+	admitted = true
+	return resp, nil
+}
+
+// admitPod decodes the pod from req when it should be reviewed. Otherwise it returns either the response to send
+// without review or an error.
+func admitPod(path string, req *v1.AdmissionRequest) (*corev1.Pod, *v1.AdmissionResponse, error) {
+	return admitSubresource(path, req, "")
+}
+
+// admitSubresource is admitPod for requests to subresource, an empty subresource being the pod itself.
+func admitSubresource(path string, req *v1.AdmissionRequest, subresource string) (*corev1.Pod, *v1.AdmissionResponse, error) {
+	// pod spec patches don't apply to subresources such as pods/status or pods/binding. Checked first so
+	// requests like pods/exec that carry no pod are allowed rather than refused in system or excluded namespaces.
+	if req.SubResource != subresource {
+		LevelDebug.Printf("status=ignored path=%s err='subresource %s'", path, req.SubResource)
+		audit(path, req, DecisionSubresource)
+		return nil, allow(req), nil
+	}
+
+	if len(NamespaceAllowlist) > 0 && !NamespaceAllowlist[req.Namespace] {
+		LevelDebug.Printf("status=ignored path=%s err='namespace %s not in allowlist'", path, req.Namespace)
+		audit(path, req, DecisionAllowlist)
+		return nil, allow(req), nil
+	}
+
+	// the allowlist overrides isSystem except for majortom's own namespace which is never mutated.
+	if isSystem(req.Namespace) && (len(NamespaceAllowlist) == 0 || req.Namespace == OwnNamespace) {
+		LevelDebug.Printf("status=ignored path=%s err='system namespace %s'", path, req.Namespace)
+		audit(path, req, DecisionSystemNamespace)
+		return nil, allow(req), nil
+	}
+
+	if isExcludedByLabel(req.Namespace) {
+		LevelDebug.Printf("status=ignored path=%s err='excluded namespace %s'", path, req.Namespace)
+		audit(path, req, DecisionOptOut)
+		return nil, allow(req), nil
+	}
+
+	if req.Resource != podResource && IgnoreOtherResources {
+		LevelDebug.Printf("status=ignored path=%s err='unexpected resource %#v'", path, req.Resource)
+		audit(path, req, DecisionOtherResource)
+		return nil, allow(req), nil
+	}
+
+	if req.Resource != podResource {
+		LevelWarn.Printf("status=failed path=%s err='unexpected resource got %#v, want %#v'", path, req.Resource, podResource)
+		audit(path, req, DecisionWrongResource)
+		return nil, nil, &ReviewError{http.StatusBadRequest, "resource not a v1.Pod"}
+	}
+
+	pod, err := decodePod(req.Object.Raw)
+	if err != nil {
+		LevelWarn.Printf("status=failed path=%s err='pod unmarshal: %v'", path, err)
+		audit(path, req, DecisionInvalidPod)
+		return nil, nil, &ReviewError{http.StatusBadRequest, "unable to unmarshal kubernetes v1.Pod"}
+	}
+
+	// subresources such as ephemeralcontainers target pods that were processed when they were created.
+	if subresource == "" && processed(pod, path) {
+		LevelDebug.Printf("status=ignored path=%s err='already processed'", path)
+		audit(path, req, DecisionProcessed)
+		return nil, allow(req), nil
+	}
+
+	key, ok := excludedToleration(pod)
+	if ok {
+		LevelDebug.Printf("status=ignored path=%s err='excluded toleration %s'", path, key)
+		audit(path, req, DecisionToleration)
+		return nil, allow(req), nil
+	}
+
+	return pod, nil, nil
+}
+
+var ErrEmptyObject = fmt.Errorf("empty object")
+
+// podDecoder decodes pods with the client-go scheme, recognising the serializations the apiserver may send and
+// rejecting versions it doesn't know.
+var podDecoder = scheme.Codecs.UniversalDeserializer()
+
+// decodePod decodes raw as a v1 Pod, a raw object without apiVersion and kind is assumed to be one. The
+// EphemeralContainers object apiservers before 1.23 send for the ephemeralcontainers subresource is decoded as a pod
+// with its metadata and ephemeral containers.
+func decodePod(raw []byte) (*corev1.Pod, error) {
+	// the decoder treats an empty object as a zero pod rather than an error.
+	if len(raw) == 0 {
+		return nil, ErrEmptyObject
+	}
+	obj, gvk, err := podDecoder.Decode(raw, nil, &corev1.Pod{})
+	if err != nil {
+		return nil, err
+	}
+	ec, ok := obj.(*corev1.EphemeralContainers)
+	if ok {
+		return &corev1.Pod{ObjectMeta: ec.ObjectMeta, Spec: corev1.PodSpec{EphemeralContainers: ec.EphemeralContainers}}, nil
+	}
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return nil, fmt.Errorf("decoded %s, want v1 Pod", gvk)
+	}
+	return pod, nil
+}
+
+// excludedToleration returns the first of the pod's toleration keys in ExcludeTolerations.
+func excludedToleration(pod *corev1.Pod) (string, bool) {
+	for _, t := range pod.Spec.Tolerations {
+		if ExcludeTolerations[t.Key] {
+			return t.Key, true
+		}
+	}
+	return "", false
+}
+
+// allow is an allowing response without a patch.
+func allow(req *v1.AdmissionRequest) *v1.AdmissionResponse {
+	return &v1.AdmissionResponse{UID: req.UID, Allowed: true}
+}
+
+// FieldManager returns the fieldManager from the request's create or update options, empty when the options are
+// absent or can't be decoded. RequestPatchables can use it to attribute mutations to the actor.
+func FieldManager(req *v1.AdmissionRequest) string {
+	if len(req.Options.Raw) == 0 {
+		return ""
+	}
+	// CreateOptions and UpdateOptions share the fieldManager field so either decodes.
+	var opts metav1.UpdateOptions
+	err := json.Unmarshal(req.Options.Raw, &opts)
+	if err != nil {
+		LevelDebug.Printf("status=ignored uid=%s err='options unmarshal: %v'", req.UID, err)
+		return ""
+	}
+	return opts.FieldManager
+}
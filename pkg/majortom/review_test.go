@@ -0,0 +1,266 @@
+package majortom
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	v1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func Test_Review(t *testing.T) {
+	cases := map[string]struct {
+		req     *v1.AdmissionRequest
+		allowed bool
+		patch   bool
+		code    int
+	}{
+		"happy path":         {&v1.AdmissionRequest{UID: "abc123", Namespace: "default", Resource: resourcePods, Object: tidePod()}, true, true, 0},
+		"pod with owner":     {&v1.AdmissionRequest{UID: "abc123", Namespace: "default", Resource: resourcePods, Object: podWithOwnerLabel()}, false, false, http.StatusForbidden},
+		"system namespace":   {&v1.AdmissionRequest{UID: "abc123", Namespace: "kube-system", Resource: resourcePods, Object: tidePod()}, true, false, 0},
+		"other resource":     {&v1.AdmissionRequest{UID: "abc123", Namespace: "default", Resource: metav1.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}}, true, false, 0},
+		"empty pod payload":  {&v1.AdmissionRequest{UID: "abc123", Namespace: "default", Resource: resourcePods}, false, false, http.StatusBadRequest},
+		"status subresource": {&v1.AdmissionRequest{UID: "abc123", Namespace: "default", Resource: resourcePods, SubResource: "status", Object: tidePod()}, true, false, 0},
+	}
+
+	for n, tc := range cases {
+		tc := tc
+		t.Run(n, func(t *testing.T) {
+			resp, err := Review(tc.req, AddOwner)
+			if tc.code != 0 {
+				var re *ReviewError
+				if !errors.As(err, &re) || re.Code != tc.code {
+					t.Fatalf("err=%v, want ReviewError with code %v", err, tc.code)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("err=%v, want nil", err)
+			}
+			if resp.UID != "abc123" {
+				t.Errorf("resp.UID=%v, want abc123", resp.UID)
+			}
+			if resp.Allowed != tc.allowed {
+				t.Errorf("resp.Allowed=%v, want %v", resp.Allowed, tc.allowed)
+			}
+			actual := resp.Patch != nil
+			if actual != tc.patch {
+				t.Errorf("patched=%v, want %v", actual, tc.patch)
+			}
+		})
+	}
+}
+
+func Test_Review_allows_pod_subresources(t *testing.T) {
+	IgnoreOtherResources = false
+	defer func() { IgnoreOtherResources = true }()
+	execOptions := runtime.RawExtension{Raw: []byte(`{"kind":"PodExecOptions","apiVersion":"v1","stdin":true,"tty":true,"container":"app","command":["sh"]}`)}
+	cases := map[string]struct {
+		namespace   string
+		subresource string
+	}{
+		"exec":                  {"default", "exec"},
+		"attach":                {"default", "attach"},
+		"log":                   {"default", "log"},
+		"exec system namespace": {"kube-system", "exec"},
+	}
+
+	for n, tc := range cases {
+		tc := tc
+		t.Run(n, func(t *testing.T) {
+			req := &v1.AdmissionRequest{UID: "abc123", Namespace: tc.namespace, Operation: v1.Connect, Resource: resourcePods, SubResource: tc.subresource, Object: execOptions}
+			resp, err := Review(req, AddOwner)
+			if err != nil {
+				t.Fatalf("err=%v, want nil", err)
+			}
+			if !resp.Allowed || resp.Patch != nil {
+				t.Errorf("resp=%#v, want allowed without patch", resp)
+			}
+		})
+	}
+}
+
+func Test_Review_fail_closed_denies(t *testing.T) {
+	DefaultFailPolicy = FailClosed
+	defer func() { DefaultFailPolicy = FailWebhook }()
+	resp, err := Review(&v1.AdmissionRequest{UID: "abc123", Namespace: "default", Resource: resourcePods, Object: podWithOwnerLabel()}, AddOwner)
+	if err != nil {
+		t.Fatalf("err=%v, want nil", err)
+	}
+	if resp.Allowed {
+		t.Error("resp.Allowed=true, want false")
+	}
+}
+
+func Test_Review_excluded_toleration(t *testing.T) {
+	ExcludeTolerations = stringSet{"node-role.kubernetes.io/control-plane": true}
+	defer func() { ExcludeTolerations = stringSet{} }()
+	cases := map[string]struct {
+		tolerations []corev1.Toleration
+		patch       bool
+	}{
+		"control-plane toleration": {[]corev1.Toleration{{Key: "node-role.kubernetes.io/control-plane", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoSchedule}}, false},
+		"other toleration":         {[]corev1.Toleration{{Key: "dedicated", Operator: corev1.TolerationOpEqual, Value: "gpu"}}, true},
+		"no tolerations":           {nil, true},
+	}
+
+	for n, tc := range cases {
+		tc := tc
+		t.Run(n, func(t *testing.T) {
+			pod := &corev1.Pod{Spec: corev1.PodSpec{Tolerations: tc.tolerations, Containers: []corev1.Container{{Image: "nginx:latest"}}}}
+			raw, _ := json.Marshal(pod)
+			resp, err := Review(&v1.AdmissionRequest{UID: "abc123", Namespace: "default", Resource: resourcePods, Object: runtime.RawExtension{Raw: raw}}, AddOwner)
+			if err != nil {
+				t.Fatalf("err=%v, want nil", err)
+			}
+			if !resp.Allowed {
+				t.Error("resp.Allowed=false, want true")
+			}
+			actual := resp.Patch != nil
+			if actual != tc.patch {
+				t.Errorf("patched=%v, want %v", actual, tc.patch)
+			}
+		})
+	}
+}
+
+func Test_Review_patch_not_html_escaped(t *testing.T) {
+	url := "https://junctionbox.ca/callback?a=1&b=<2>"
+	resp, err := Review(&v1.AdmissionRequest{UID: "abc123", Namespace: "default", Resource: resourcePods, Object: tidePod()}, AnnotationsPatch(map[string]string{"callback": url}))
+	if err != nil {
+		t.Fatalf("err=%v, want nil", err)
+	}
+	if !bytes.Contains(resp.Patch, []byte(url)) {
+		t.Errorf("patch=%s, want containing %v unescaped", resp.Patch, url)
+	}
+}
+
+func Test_Review_logs_field_manager(t *testing.T) {
+	buf := captureLog(t)
+	LogLevel = LevelDebug
+	defer func() { LogLevel = LevelInfo }()
+	dryRun := true
+	req := &v1.AdmissionRequest{
+		UID:       "abc123",
+		Namespace: "default",
+		Operation: v1.Create,
+		Resource:  resourcePods,
+		DryRun:    &dryRun,
+		Options:   runtime.RawExtension{Raw: []byte(`{"kind":"CreateOptions","apiVersion":"meta.k8s.io/v1","fieldManager":"kubectl-client-side-apply","dryRun":["All"]}`)},
+		Object:    tidePod(),
+	}
+	if FieldManager(req) != "kubectl-client-side-apply" {
+		t.Errorf("FieldManager=%v, want kubectl-client-side-apply", FieldManager(req))
+	}
+	_, err := Review(req, AddOwner)
+	if err != nil {
+		t.Fatalf("err=%v, want nil", err)
+	}
+	out := buf.String()
+	if !strings.Contains(out, "field-manager=kubectl-client-side-apply dry-run=true") {
+		t.Errorf("log=%q, want field-manager and dry-run", out)
+	}
+}
+
+func Test_FieldManager_without_options(t *testing.T) {
+	if FieldManager(&v1.AdmissionRequest{}) != "" {
+		t.Errorf("FieldManager=%v, want empty", FieldManager(&v1.AdmissionRequest{}))
+	}
+}
+
+func Test_Review_skips_processed_pods(t *testing.T) {
+	cases := map[string]struct {
+		processed string
+		patch     bool
+	}{
+		"unprocessed":          {"", true},
+		"processed by route":   {"/labels/team,/labels/owner", false},
+		"processed by another": {"/labels/team", true},
+	}
+
+	for n, tc := range cases {
+		tc := tc
+		t.Run(n, func(t *testing.T) {
+			pod := &corev1.Pod{}
+			if tc.processed != "" {
+				pod.Annotations = map[string]string{ProcessedAnnotation: tc.processed}
+			}
+			raw, _ := json.Marshal(pod)
+			req := &v1.AdmissionRequest{UID: "abc123", Namespace: "default", Resource: resourcePods, Object: runtime.RawExtension{Raw: raw}}
+			resp, err := reviewPod("/labels/owner", req, AddOwner, DefaultFailPolicy)
+			if err != nil {
+				t.Fatalf("err=%v, want nil", err)
+			}
+			if !resp.Allowed {
+				t.Error("resp.Allowed=false, want true")
+			}
+			if (resp.Patch != nil) != tc.patch {
+				t.Fatalf("patched=%v, want %v", resp.Patch != nil, tc.patch)
+			}
+			if !tc.patch {
+				return
+			}
+			var ops []operation
+			_ = json.Unmarshal(resp.Patch, &ops)
+			patched := applyPatch(t, pod, ops)
+			if !processed(patched, "/labels/owner") {
+				t.Errorf("annotations[%s]=%v, want /labels/owner recorded", ProcessedAnnotation, patched.Annotations[ProcessedAnnotation])
+			}
+			// a second admission of the patched pod, e.g. a reinvocation, isn't patched again.
+			raw, _ = json.Marshal(patched)
+			req.Object = runtime.RawExtension{Raw: raw}
+			resp, _ = reviewPod("/labels/owner", req, AddOwner, DefaultFailPolicy)
+			if resp.Patch != nil {
+				t.Errorf("re-review patch=%s, want nil", resp.Patch)
+			}
+		})
+	}
+}
+
+func Test_decodePod(t *testing.T) {
+	pod := &corev1.Pod{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", Labels: map[string]string{"app": "web"}},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "nginx:1.19.2"}}},
+	}
+	typed, _ := json.Marshal(pod)
+	bare, _ := json.Marshal(&corev1.Pod{ObjectMeta: pod.ObjectMeta, Spec: pod.Spec})
+	cases := map[string]struct {
+		raw string
+		err string
+	}{
+		"typed":           {string(typed), ""},
+		"without kind":    {string(bare), ""},
+		"unknown version": {`{"apiVersion":"v2","kind":"Pod"}`, `no kind "Pod" is registered for version "v2"`},
+		"other kind":      {`{"apiVersion":"apps/v1","kind":"Deployment"}`, "decoded apps/v1, Kind=Deployment, want v1 Pod"},
+		"empty":           {"", "empty object"},
+	}
+
+	for n, tc := range cases {
+		tc := tc
+		t.Run(n, func(t *testing.T) {
+			decoded, err := decodePod([]byte(tc.raw))
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("err=%v, want containing %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("err=%v, want nil", err)
+			}
+			var expected corev1.Pod
+			_ = json.Unmarshal([]byte(tc.raw), &expected)
+			if !cmp.Equal(decoded, &expected) {
+				t.Errorf("pod mismatch (+want -got)\n%s", cmp.Diff(decoded, &expected))
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+// Package majortom is the admission core of the majortom webhook.
+package majortom
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Router registers admission routes against their patchables and exposes them as a http.Handler so majortom can be
+// embedded within another server.
+type Router struct {
+	mux          *http.ServeMux
+	routes       []string
+	patchables   map[string]PodPatchable
+	kinds        map[string]string
+	descriptions map[string]string
+	policies     map[string]FailPolicy
+}
+
+// NewRouter returns an empty Router.
+func NewRouter() *Router {
+	return &Router{
+		mux:          http.NewServeMux(),
+		patchables:   map[string]PodPatchable{},
+		kinds:        map[string]string{},
+		descriptions: map[string]string{},
+		policies:     map[string]FailPolicy{},
+	}
+}
+
+// register records the route path of kind served by h, p is nil for routes that don't patch.
+func (rt *Router) register(path, kind string, h http.Handler, p PodPatchable) {
+	rt.mux.Handle(path, instrument(path, h))
+	rt.routes = append(rt.routes, path)
+	rt.kinds[path] = kind
+	if p != nil {
+		rt.patchables[path] = p
+	}
+}
+
+// Handle registers the patchable p as a mutating admission endpoint on path.
+func (rt *Router) Handle(path string, p PodPatchable) {
+	rt.register(path, "mutate", bind(podPatch, p, rt.policy(path)), p)
+}
+
+// HandleRequest registers the request patchable rp as a mutating admission endpoint on path. Without a request it
+// can't be previewed or self checked.
+func (rt *Router) HandleRequest(path string, rp RequestPatchable) {
+	rt.register(path, "mutate-request", bindRequest(rp, rt.policy(path)), nil)
+}
+
+// HandleRecommend registers the patchable p as a mutating admission endpoint on path that warns the requester with
+// warning whenever it patches a pod.
+func (rt *Router) HandleRecommend(path string, p PodPatchable, warning string) {
+	rt.register(path, "recommend", bindRecommend(p, warning, rt.policy(path)), p)
+}
+
+// HandleEphemeral registers the request patchable rp as a mutating admission endpoint on path for the pods
+// ephemeralcontainers subresource, requests for the pod itself are allowed unmodified.
+func (rt *Router) HandleEphemeral(path string, rp RequestPatchable) {
+	rt.register(path, "ephemeral", bindEphemeral(rp, rt.policy(path)), nil)
+}
+
+// HandleValidate registers the validatable v as a validating admission endpoint on path.
+func (rt *Router) HandleValidate(path string, v PodValidatable, mode ValidationMode) {
+	rt.register(path, "validate-"+mode.String(), bindValidate(v, mode), nil)
+}
+
+// HandleValidateThenPatch registers a mutating admission endpoint on path that denies pods failing v and patches the
+// others with p.
+func (rt *Router) HandleValidateThenPatch(path string, v PodValidatable, p PodPatchable) {
+	rt.register(path, "validate-mutate", bindValidateThenPatch(v, p, rt.policy(path)), p)
+}
+
+// policy resolves the FailPolicy of the route on path per request, routes without one in policies follow the
+// DefaultFailPolicy.
+func (rt *Router) policy(path string) func() FailPolicy {
+	return func() FailPolicy {
+		p, ok := rt.policies[path]
+		if !ok {
+			return DefaultFailPolicy
+		}
+		return p
+	}
+}
+
+// Describe records a human readable description of the patchables served on path for the startup summary.
+func (rt *Router) Describe(path, description string) {
+	rt.descriptions[path] = description
+}
+
+// Summary lists each registered route as path:kind[description] in registration order.
+func (rt *Router) Summary() string {
+	summaries := make([]string, 0, len(rt.routes))
+	for _, path := range rt.routes {
+		summaries = append(summaries, fmt.Sprintf("%s:%s[%s]", path, rt.kinds[path], rt.descriptions[path]))
+	}
+	return strings.Join(summaries, ",")
+}
+
+// RouteInfo describes a registered route in the /routes listing.
+type RouteInfo struct {
+	Path        string `json:"path"`
+	Kind        string `json:"kind"`
+	Description string `json:"description,omitempty"`
+}
+
+// Routes lists the registered routes in registration order.
+func (rt *Router) Routes() []RouteInfo {
+	routes := make([]RouteInfo, 0, len(rt.routes))
+	for _, path := range rt.routes {
+		routes = append(routes, RouteInfo{Path: path, Kind: rt.kinds[path], Description: rt.descriptions[path]})
+	}
+	return routes
+}
+
+// RoutesHandler returns a handler listing Routes as JSON so operators can confirm the live config.
+func (rt *Router) RoutesHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", ApplicationJson)
+		err := json.NewEncoder(w).Encode(rt.Routes())
+		if err != nil {
+			LevelError.Printf("status=failed path=%s err='routes marshal: %v'", r.URL.Path, err)
+		}
+	})
+}
+
+// Mux returns the handler for all registered routes.
+func (rt *Router) Mux() http.Handler {
+	return rt.mux
+}
+
+// Preview returns a handler that applies a registered route's patchable to a raw pod, see preview.
+func (rt *Router) Preview() http.Handler {
+	return http.HandlerFunc(rt.preview)
+}
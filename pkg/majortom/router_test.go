@@ -0,0 +1,91 @@
+package majortom
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	v1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func Test_Router_custom_patchable(t *testing.T) {
+	custom := func(pod *corev1.Pod) ([]operation, error) {
+		return []operation{addOp("/metadata/labels", map[string]interface{}{"team": "platform"})}, nil
+	}
+	rt := NewRouter()
+	rt.Handle("/labels/team", custom)
+	srv := httptest.NewServer(rt.Mux())
+	defer srv.Close()
+
+	body := post(&v1.AdmissionReview{Request: &v1.AdmissionRequest{Namespace: "default", Resource: resourcePods, Object: tidePod()}}).Body
+	resp, err := http.Post(srv.URL+"/labels/team", ApplicationJson, body)
+	if err != nil {
+		t.Fatalf("Post err=%v, want nil", err)
+	}
+	defer closer(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("resp.StatusCode=%v, want StatusOK", resp.StatusCode)
+	}
+
+	var review v1.AdmissionReview
+	err = json.NewDecoder(resp.Body).Decode(&review)
+	if err != nil {
+		t.Fatalf("Decode err=%v, want nil", err)
+	}
+	expected := `[{"op":"add","path":"/metadata/labels","value":{"team":"platform"}},{"op":"add","path":"/metadata/annotations","value":{"majortom.io/processed":"/labels/team","majortom.io/revision":"dev"}}]`
+	if string(review.Response.Patch) != expected {
+		t.Errorf("patch=%s, want %s", review.Response.Patch, expected)
+	}
+}
+
+func Test_Router_unregistered_path(t *testing.T) {
+	rt := NewRouter()
+	rt.Handle("/labels/team", AddOwner)
+	r := post(&v1.AdmissionReview{})
+	r.URL.Path = "/labels/owner"
+	w := httptest.NewRecorder()
+	rt.Mux().ServeHTTP(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("w.Code=%v, want StatusNotFound", w.Code)
+	}
+}
+
+func Test_Router_Summary(t *testing.T) {
+	rt := NewRouter()
+	rt.Handle("/labels/owner", AddOwner)
+	rt.Describe("/labels/owner", "owner")
+	rt.HandleValidate("/validate/latest", forbidAll, Warn)
+	expected := "/labels/owner:mutate[owner],/validate/latest:validate-warn[]"
+	if rt.Summary() != expected {
+		t.Errorf("summary()=%v, want %v", rt.Summary(), expected)
+	}
+}
+
+func Test_Router_RoutesHandler(t *testing.T) {
+	rt, err := loadRouter()
+	if err != nil {
+		t.Fatalf("loadRouter err=%v, want nil", err)
+	}
+	rt.HandleValidate("/validate/latest", forbidAll, Enforce)
+	w := httptest.NewRecorder()
+	rt.RoutesHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/routes", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("w.Code=%v, want StatusOK", w.Code)
+	}
+
+	var routes []RouteInfo
+	err = json.NewDecoder(w.Body).Decode(&routes)
+	if err != nil {
+		t.Fatalf("Decode err=%v, want nil", err)
+	}
+	expected := []RouteInfo{
+		{Path: "/labels/owner", Kind: "mutate", Description: "vars"},
+		{Path: "/validate/latest", Kind: "validate-enforce"},
+	}
+	if !cmp.Equal(routes, expected) {
+		t.Errorf("routes mismatch (+want -got)\n%s", cmp.Diff(routes, expected))
+	}
+}
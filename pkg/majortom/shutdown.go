@@ -0,0 +1,55 @@
+package majortom
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// RetryAfter is the Retry-After in seconds sent with requests refused while draining.
+const RetryAfter = 1
+
+// Drainer refuses requests once shutdown has begun so the apiserver retries against another replica rather than
+// having its connection reset.
+type Drainer struct {
+	draining int32
+}
+
+// drainer gates the admission routes served by Exec.
+var drainer = &Drainer{}
+
+// Begin starts draining, subsequent requests are refused.
+func (d *Drainer) Begin() {
+	atomic.StoreInt32(&d.draining, 1)
+}
+
+// Draining reports whether Begin has been called.
+func (d *Drainer) Draining() bool {
+	return atomic.LoadInt32(&d.draining) == 1
+}
+
+// Wrap returns a handler responding 503 with a Retry-After header while draining and h otherwise.
+func (d *Drainer) Wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if d.Draining() {
+			LevelDebug.Printf("status=refused path=%s err='shutting down'", r.URL.Path)
+			w.Header().Set("Retry-After", strconv.Itoa(RetryAfter))
+			httpError(w, "shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// gracefulShutdown begins draining d, waits delay for endpoints to stop routing to this replica and then shuts
+// server down allowing in-flight requests up to timeout to complete.
+func gracefulShutdown(server *http.Server, d *Drainer, delay, timeout time.Duration) error {
+	d.Begin()
+	LevelInfo.Printf("status=draining delay=%s", delay)
+	time.Sleep(delay)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return server.Shutdown(ctx)
+}
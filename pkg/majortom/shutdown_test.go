@@ -0,0 +1,63 @@
+package majortom
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_Drainer_Wrap(t *testing.T) {
+	d := &Drainer{}
+	h := d.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("w.Code=%v, want StatusOK", w.Code)
+	}
+
+	d.Begin()
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("w.Code=%v, want StatusServiceUnavailable", w.Code)
+	}
+	if w.Header().Get("Retry-After") != "1" {
+		t.Errorf("Retry-After=%v, want 1", w.Header().Get("Retry-After"))
+	}
+}
+
+func Test_gracefulShutdown_refuses_requests(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen err=%v, want nil", err)
+	}
+	d := &Drainer{}
+	server := &http.Server{Handler: d.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))}
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(l) }()
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- gracefulShutdown(server, d, 200*time.Millisecond, time.Second) }()
+
+	for !d.Draining() {
+		time.Sleep(time.Millisecond)
+	}
+	resp, err := http.Post("http://"+l.Addr().String()+"/", ApplicationJson, nil)
+	if err != nil {
+		t.Fatalf("Post err=%v, want nil", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("StatusCode=%v, want StatusServiceUnavailable", resp.StatusCode)
+	}
+
+	err = <-shutdown
+	if err != nil {
+		t.Errorf("gracefulShutdown err=%v, want nil", err)
+	}
+	err = <-served
+	if err != http.ErrServerClosed {
+		t.Errorf("Serve err=%v, want ErrServerClosed", err)
+	}
+}
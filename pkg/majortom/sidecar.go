@@ -0,0 +1,113 @@
+package majortom
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+)
+
+// InjectedContainersAnnotation records the comma separated names of the containers majortom injected into a pod.
+const InjectedContainersAnnotation = "majortom.io/injected-containers"
+
+// DefaultContainerAnnotation names the container kubectl exec and logs use when none is specified.
+const DefaultContainerAnnotation = "kubectl.kubernetes.io/default-container"
+
+// injectedContainers returns the container names recorded in the pod's InjectedContainersAnnotation.
+func injectedContainers(pod *corev1.Pod) stringSet {
+	injected := stringSet{}
+	v, ok := pod.ObjectMeta.Annotations[InjectedContainersAnnotation]
+	if ok {
+		_ = injected.Set(v)
+	}
+	return injected
+}
+
+// SidecarOption configures SidecarPatch.
+type SidecarOption func(*sidecarOptions)
+
+type sidecarOptions struct {
+	replace bool
+}
+
+// ReplaceOnConflict replaces a container with the sidecar's name whose spec differs from the sidecar rather than
+// leaving it as-is. Only the fields the sidecar sets are compared so the defaults the apiserver fills in, such as
+// terminationMessagePath, aren't a conflict.
+func ReplaceOnConflict() SidecarOption {
+	return func(opts *sidecarOptions) {
+		opts.replace = true
+	}
+}
+
+// SidecarPatch appends sidecar to the pod's containers recording it in the InjectedContainersAnnotation. Pods that
+// already have a container with the sidecar's name are left as-is unless ReplaceOnConflict is used.
+func SidecarPatch(sidecar corev1.Container, opts ...SidecarOption) PodPatchable {
+	options := &sidecarOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	return func(pod *corev1.Pod) ([]operation, error) {
+		var ops []operation
+		for i, c := range pod.Spec.Containers {
+			if c.Name != sidecar.Name {
+				continue
+			}
+			if !options.replace || equality.Semantic.DeepDerivative(sidecar, c) {
+				return nil, nil
+			}
+			ops = []operation{replaceOp(fmt.Sprintf("/spec/containers/%d", i), sidecar)}
+			break
+		}
+		if ops == nil {
+			ops = []operation{addOp("/spec/containers/-", sidecar)}
+		}
+		injected := injectedContainers(pod)
+		injected[sidecar.Name] = true
+		return append(ops, mapEntriesOps(annotationsPath, pod.ObjectMeta.Annotations, map[string]string{InjectedContainersAnnotation: injected.String()})...), nil
+	}
+}
+
+// DefaultContainerAnnotationPatch points the DefaultContainerAnnotation at the first container majortom didn't
+// inject so kubectl targets the app rather than a sidecar. An existing annotation is left untouched.
+func DefaultContainerAnnotationPatch() PodPatchable {
+	return func(pod *corev1.Pod) ([]operation, error) {
+		_, ok := pod.ObjectMeta.Annotations[DefaultContainerAnnotation]
+		if ok {
+			return nil, nil
+		}
+		injected := injectedContainers(pod)
+		for _, c := range pod.Spec.Containers {
+			if !injected[c.Name] {
+				return mapEntriesOps(annotationsPath, pod.ObjectMeta.Annotations, map[string]string{DefaultContainerAnnotation: c.Name}), nil
+			}
+		}
+		return nil, nil
+	}
+}
+
+// LogsVolume is the volume a pod declares to have its logs shipped by LogForwarderPatch.
+const LogsVolume = "logs"
+
+// LogForwarderPatch injects a log forwarder running image into pods that declare the LogsVolume as an emptyDir the
+// app writes its log files to, a PVC or hostPath named logs is left alone. The forwarder mounts it read-only alongside an emptyDir of its own for
+// tracking how far it has read, which survives forwarder restarts.
+func LogForwarderPatch(image string) PodPatchable {
+	state := corev1.Volume{Name: "log-forwarder-state", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}}
+	forwarder := corev1.Container{
+		Name:  "log-forwarder",
+		Image: image,
+		VolumeMounts: []corev1.VolumeMount{
+			{Name: LogsVolume, MountPath: "/var/log/app", ReadOnly: true},
+			{Name: state.Name, MountPath: "/var/lib/log-forwarder"},
+		},
+	}
+	patch := Chain(VolumePatch(state), SidecarPatch(forwarder))
+	return func(pod *corev1.Pod) ([]operation, error) {
+		for _, v := range pod.Spec.Volumes {
+			if v.Name == LogsVolume && v.EmptyDir != nil {
+				return patch(pod)
+			}
+		}
+		return nil, nil
+	}
+}
@@ -0,0 +1,178 @@
+package majortom
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var logForwarder = corev1.Container{Name: "log-forwarder", Image: "fluent/fluent-bit:1.6"}
+
+func Test_SidecarPatch(t *testing.T) {
+	pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "nginx:latest"}}}}
+	ops, err := SidecarPatch(logForwarder)(pod)
+	if err != nil {
+		t.Fatalf("err=%v, want nil", err)
+	}
+	patched := applyPatch(t, pod, ops)
+	if len(patched.Spec.Containers) != 2 || patched.Spec.Containers[1].Name != "log-forwarder" {
+		t.Errorf("containers=%v, want app and log-forwarder", patched.Spec.Containers)
+	}
+	if patched.Annotations[InjectedContainersAnnotation] != "log-forwarder" {
+		t.Errorf("annotations=%v, want %s=log-forwarder", patched.Annotations, InjectedContainersAnnotation)
+	}
+
+	ops, err = SidecarPatch(logForwarder)(patched)
+	if err != nil {
+		t.Fatalf("err=%v, want nil", err)
+	}
+	if len(ops) != 0 {
+		t.Errorf("ops=%v, want none once injected", ops)
+	}
+}
+
+func Test_DefaultContainerAnnotationPatch(t *testing.T) {
+	cases := map[string]struct {
+		annotations map[string]string
+		want        string
+	}{
+		"unset":       {nil, "app"},
+		"already set": {map[string]string{DefaultContainerAnnotation: "debug"}, "debug"},
+	}
+
+	for n, tc := range cases {
+		tc := tc
+		t.Run(n, func(t *testing.T) {
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: tc.annotations},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "nginx:latest"}}},
+			}
+			sidecar := corev1.Container{Name: "agent", Image: "busybox"}
+			ops, err := Chain(SidecarPatch(sidecar), DefaultContainerAnnotationPatch())(pod)
+			if err != nil {
+				t.Fatalf("err=%v, want nil", err)
+			}
+			patched := applyPatch(t, pod, ops)
+			if patched.Annotations[DefaultContainerAnnotation] != tc.want {
+				t.Errorf("annotations[%s]=%v, want %v", DefaultContainerAnnotation, patched.Annotations[DefaultContainerAnnotation], tc.want)
+			}
+		})
+	}
+}
+
+func Test_DefaultContainerAnnotationPatch_skips_injected_first_container(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{InjectedContainersAnnotation: "istio-proxy"}},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{
+			{Name: "istio-proxy", Image: "istio/proxyv2"},
+			{Name: "app", Image: "nginx:latest"},
+		}},
+	}
+	ops, _ := DefaultContainerAnnotationPatch()(pod)
+	patched := applyPatch(t, pod, ops)
+	if patched.Annotations[DefaultContainerAnnotation] != "app" {
+		t.Errorf("annotations[%s]=%v, want app", DefaultContainerAnnotation, patched.Annotations[DefaultContainerAnnotation])
+	}
+}
+
+func Test_SidecarPatch_ReplaceOnConflict(t *testing.T) {
+	outdated := corev1.Container{Name: "log-forwarder", Image: "fluent/fluent-bit:1.4"}
+	defaulted := logForwarder
+	defaulted.ImagePullPolicy = corev1.PullIfNotPresent
+	defaulted.TerminationMessagePath = corev1.TerminationMessagePathDefault
+	defaulted.TerminationMessagePolicy = corev1.TerminationMessageReadFile
+	cases := map[string]struct {
+		containers []corev1.Container
+		ops        []string
+		want       []corev1.Container
+	}{
+		"identical":       {[]corev1.Container{{Name: "app"}, logForwarder}, nil, []corev1.Container{{Name: "app"}, logForwarder}},
+		"defaulted":       {[]corev1.Container{{Name: "app"}, defaulted}, nil, []corev1.Container{{Name: "app"}, defaulted}},
+		"different":       {[]corev1.Container{{Name: "app"}, outdated}, []string{"replace /spec/containers/1", "add /metadata/annotations"}, []corev1.Container{{Name: "app"}, logForwarder}},
+		"not conflicting": {[]corev1.Container{{Name: "app"}}, []string{"add /spec/containers/-", "add /metadata/annotations"}, []corev1.Container{{Name: "app"}, logForwarder}},
+	}
+
+	for n, tc := range cases {
+		tc := tc
+		t.Run(n, func(t *testing.T) {
+			pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: tc.containers}}
+			ops, err := SidecarPatch(logForwarder, ReplaceOnConflict())(pod)
+			if err != nil {
+				t.Fatalf("err=%v, want nil", err)
+			}
+			var actual []string
+			for _, op := range ops {
+				actual = append(actual, op.Op+" "+op.Path)
+			}
+			if !cmp.Equal(actual, tc.ops) {
+				t.Errorf("ops mismatch (+want -got)\n%s", cmp.Diff(actual, tc.ops))
+			}
+			patched := applyPatch(t, pod, ops)
+			if !cmp.Equal(patched.Spec.Containers, tc.want) {
+				t.Errorf("containers mismatch (+want -got)\n%s", cmp.Diff(patched.Spec.Containers, tc.want))
+			}
+		})
+	}
+}
+
+func Test_SidecarPatch_skips_conflict_by_default(t *testing.T) {
+	pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "log-forwarder", Image: "fluent/fluent-bit:1.4"}}}}
+	ops, _ := SidecarPatch(logForwarder)(pod)
+	if len(ops) != 0 {
+		t.Errorf("ops=%v, want none", ops)
+	}
+}
+
+func Test_LogForwarderPatch(t *testing.T) {
+	emptyDir := corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}
+	cases := map[string]struct {
+		volumes   []corev1.Volume
+		forwarder bool
+	}{
+		"logs volume":   {[]corev1.Volume{{Name: "logs", VolumeSource: emptyDir}}, true},
+		"other volume":  {[]corev1.Volume{{Name: "cache", VolumeSource: emptyDir}}, false},
+		"logs pvc":      {[]corev1.Volume{{Name: "logs", VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "logs"}}}}, false},
+		"logs hostPath": {[]corev1.Volume{{Name: "logs", VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/var/log"}}}}, false},
+		"no volumes":    {nil, false},
+	}
+
+	for n, tc := range cases {
+		tc := tc
+		t.Run(n, func(t *testing.T) {
+			pod := &corev1.Pod{Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "app", Image: "nginx:1.19.2"}},
+				Volumes:    tc.volumes,
+			}}
+			ops, err := LogForwarderPatch("fluent/fluent-bit:1.6")(pod)
+			if err != nil {
+				t.Fatalf("err=%v, want nil", err)
+			}
+			patched := applyPatch(t, pod, ops)
+			if !tc.forwarder {
+				if len(ops) != 0 {
+					t.Errorf("len(ops)=%v, want 0", len(ops))
+				}
+				return
+			}
+			if len(patched.Spec.Containers) != 2 {
+				t.Fatalf("len(containers)=%v, want 2", len(patched.Spec.Containers))
+			}
+			mounts := map[string]bool{}
+			for _, m := range patched.Spec.Containers[1].VolumeMounts {
+				mounts[m.Name] = true
+			}
+			if !mounts["logs"] || !mounts["log-forwarder-state"] {
+				t.Errorf("forwarder mounts=%v, want logs and log-forwarder-state", patched.Spec.Containers[1].VolumeMounts)
+			}
+			volumes := map[string]bool{}
+			for _, v := range patched.Spec.Volumes {
+				volumes[v.Name] = true
+			}
+			if !volumes["logs"] || !volumes["log-forwarder-state"] {
+				t.Errorf("volumes=%v, want logs and log-forwarder-state", patched.Spec.Volumes)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package majortom
+
+import (
+	corev1 "k8s.io/api/core/v1"
+)
+
+// SysctlPatch appends sysctls to the pod securityContext skipping any already present by name.
+func SysctlPatch(sysctls []corev1.Sysctl) PodPatchable {
+	return func(pod *corev1.Pod) ([]operation, error) {
+		sc := pod.Spec.SecurityContext
+		seen := map[string]bool{}
+		if sc != nil {
+			for _, s := range sc.Sysctls {
+				seen[s.Name] = true
+			}
+		}
+
+		var missing []corev1.Sysctl
+		for _, s := range sysctls {
+			if seen[s.Name] {
+				continue
+			}
+			seen[s.Name] = true
+			missing = append(missing, s)
+		}
+		if len(missing) == 0 {
+			return nil, nil
+		}
+
+		if sc == nil {
+			return []operation{addOp("/spec/securityContext", map[string]interface{}{"sysctls": missing})}, nil
+		}
+		if sc.Sysctls == nil {
+			return []operation{addOp("/spec/securityContext/sysctls", missing)}, nil
+		}
+		var ops []operation
+		for _, s := range missing {
+			ops = append(ops, addOp("/spec/securityContext/sysctls/-", s))
+		}
+		return ops, nil
+	}
+}
+
+// VolumePatch adds volume to the pod unless it already declares a volume with the same name.
+func VolumePatch(volume corev1.Volume) PodPatchable {
+	return func(pod *corev1.Pod) ([]operation, error) {
+		for _, v := range pod.Spec.Volumes {
+			if v.Name == volume.Name {
+				return nil, nil
+			}
+		}
+		if pod.Spec.Volumes == nil {
+			return []operation{addOp("/spec/volumes", []corev1.Volume{volume})}, nil
+		}
+		return []operation{addOp("/spec/volumes/-", volume)}, nil
+	}
+}
+
+// ServiceLinksAnnotation set to "true" on a pod opts it out of DisableServiceLinksPatch for apps that rely on the
+// service env vars.
+const ServiceLinksAnnotation = "majortom.io/enable-service-links"
+
+// DisableServiceLinksPatch sets enableServiceLinks to false so pods aren't given env vars for every service in their
+// namespace, which can shadow injected vars and slow container start in large namespaces.
+func DisableServiceLinksPatch() PodPatchable {
+	return func(pod *corev1.Pod) ([]operation, error) {
+		if pod.ObjectMeta.Annotations[ServiceLinksAnnotation] == "true" {
+			return nil, nil
+		}
+		links := pod.Spec.EnableServiceLinks
+		if links == nil {
+			return []operation{addOp("/spec/enableServiceLinks", false)}, nil
+		}
+		if *links {
+			return []operation{replaceOp("/spec/enableServiceLinks", false)}, nil
+		}
+		return nil, nil
+	}
+}
+
+// MinGracePeriodPatch raises terminationGracePeriodSeconds to seconds when the pod asks for less so containers have
+// time to shutdown cleanly. Longer grace periods are left alone.
+func MinGracePeriodPatch(seconds int64) PodPatchable {
+	return func(pod *corev1.Pod) ([]operation, error) {
+		grace := pod.Spec.TerminationGracePeriodSeconds
+		if grace == nil {
+			return []operation{addOp("/spec/terminationGracePeriodSeconds", seconds)}, nil
+		}
+		if *grace < seconds {
+			return []operation{replaceOp("/spec/terminationGracePeriodSeconds", seconds)}, nil
+		}
+		return nil, nil
+	}
+}
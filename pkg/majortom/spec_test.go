@@ -0,0 +1,140 @@
+package majortom
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_SysctlPatch(t *testing.T) {
+	somaxconn := corev1.Sysctl{Name: "net.core.somaxconn", Value: "1024"}
+	portRange := corev1.Sysctl{Name: "net.ipv4.ip_local_port_range", Value: "1024 65535"}
+	cases := map[string]struct {
+		sc   *corev1.PodSecurityContext
+		want []corev1.Sysctl
+	}{
+		"nil securityContext": {nil, []corev1.Sysctl{somaxconn, portRange}},
+		"nil sysctls":         {&corev1.PodSecurityContext{}, []corev1.Sysctl{somaxconn, portRange}},
+		"existing sysctl":     {&corev1.PodSecurityContext{Sysctls: []corev1.Sysctl{{Name: "net.core.somaxconn", Value: "4096"}}}, []corev1.Sysctl{{Name: "net.core.somaxconn", Value: "4096"}, portRange}},
+	}
+
+	for n, tc := range cases {
+		tc := tc
+		t.Run(n, func(t *testing.T) {
+			pod := &corev1.Pod{Spec: corev1.PodSpec{SecurityContext: tc.sc}}
+			ops, err := SysctlPatch([]corev1.Sysctl{somaxconn, portRange, somaxconn})(pod)
+			if err != nil {
+				t.Fatalf("err=%v, want nil", err)
+			}
+			patched := applyPatch(t, pod, ops)
+			if !cmp.Equal(patched.Spec.SecurityContext.Sysctls, tc.want) {
+				t.Errorf("sysctls mismatch (+want -got)\n%s", cmp.Diff(patched.Spec.SecurityContext.Sysctls, tc.want))
+			}
+		})
+	}
+}
+
+func Test_SysctlPatch_all_present(t *testing.T) {
+	pod := &corev1.Pod{Spec: corev1.PodSpec{SecurityContext: &corev1.PodSecurityContext{Sysctls: []corev1.Sysctl{{Name: "net.core.somaxconn", Value: "4096"}}}}}
+	ops, err := SysctlPatch([]corev1.Sysctl{{Name: "net.core.somaxconn", Value: "1024"}})(pod)
+	if err != nil {
+		t.Errorf("err=%v, want nil", err)
+	}
+	if len(ops) != 0 {
+		t.Errorf("len(ops)=%v, want 0", len(ops))
+	}
+}
+
+func Test_VolumePatch(t *testing.T) {
+	volume := corev1.Volume{Name: "scratch", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}}
+	cases := map[string]struct {
+		volumes []corev1.Volume
+		want    int
+	}{
+		"no volumes":    {nil, 1},
+		"other volumes": {[]corev1.Volume{{Name: "config"}}, 2},
+		"present":       {[]corev1.Volume{{Name: "scratch"}}, 1},
+	}
+
+	for n, tc := range cases {
+		tc := tc
+		t.Run(n, func(t *testing.T) {
+			pod := &corev1.Pod{Spec: corev1.PodSpec{Volumes: tc.volumes}}
+			ops, err := VolumePatch(volume)(pod)
+			if err != nil {
+				t.Fatalf("err=%v, want nil", err)
+			}
+			patched := applyPatch(t, pod, ops)
+			if len(patched.Spec.Volumes) != tc.want {
+				t.Errorf("len(volumes)=%v, want %v", len(patched.Spec.Volumes), tc.want)
+			}
+		})
+	}
+}
+
+func Test_DisableServiceLinksPatch(t *testing.T) {
+	enabled, disabled := true, false
+	cases := map[string]struct {
+		links       *bool
+		annotations map[string]string
+		ops         int
+		want        bool
+	}{
+		"nil":     {nil, nil, 1, false},
+		"true":    {&enabled, nil, 1, false},
+		"false":   {&disabled, nil, 0, false},
+		"opt-out": {&enabled, map[string]string{ServiceLinksAnnotation: "true"}, 0, true},
+	}
+
+	for n, tc := range cases {
+		tc := tc
+		t.Run(n, func(t *testing.T) {
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: tc.annotations}, Spec: corev1.PodSpec{EnableServiceLinks: tc.links}}
+			ops, err := DisableServiceLinksPatch()(pod)
+			if err != nil {
+				t.Fatalf("err=%v, want nil", err)
+			}
+			if len(ops) != tc.ops {
+				t.Errorf("len(ops)=%v, want %v", len(ops), tc.ops)
+			}
+			patched := applyPatch(t, pod, ops)
+			if patched.Spec.EnableServiceLinks == nil || *patched.Spec.EnableServiceLinks != tc.want {
+				t.Errorf("enableServiceLinks=%v, want %v", patched.Spec.EnableServiceLinks, tc.want)
+			}
+		})
+	}
+}
+
+func Test_MinGracePeriodPatch(t *testing.T) {
+	short, long := int64(2), int64(60)
+	cases := map[string]struct {
+		grace *int64
+		ops   int
+		want  int64
+	}{
+		"nil":       {nil, 1, 10},
+		"below min": {&short, 1, 10},
+		"above min": {&long, 0, 60},
+	}
+
+	for n, tc := range cases {
+		tc := tc
+		t.Run(n, func(t *testing.T) {
+			pod := &corev1.Pod{Spec: corev1.PodSpec{TerminationGracePeriodSeconds: tc.grace}}
+			ops, err := MinGracePeriodPatch(10)(pod)
+			if err != nil {
+				t.Fatalf("err=%v, want nil", err)
+			}
+			if len(ops) != tc.ops {
+				t.Errorf("len(ops)=%v, want %v", len(ops), tc.ops)
+			}
+			patched := applyPatch(t, pod, ops)
+			grace := patched.Spec.TerminationGracePeriodSeconds
+			if grace == nil || *grace != tc.want {
+				t.Errorf("terminationGracePeriodSeconds=%v, want %v", grace, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package majortom
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+
+	v1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// PodValidatable returns a non-nil error when the pod violates a policy. A *ValidationError reports the individual
+// fields that caused the denial.
+type PodValidatable func(*corev1.Pod) error
+
+// ValidationError is a denial with per-field causes which are surfaced in the response status details.
+type ValidationError struct {
+	Message string
+	Causes  []metav1.StatusCause
+}
+
+func (e *ValidationError) Error() string {
+	if len(e.Causes) == 0 {
+		return e.Message
+	}
+	msgs := make([]string, 0, len(e.Causes))
+	for _, c := range e.Causes {
+		msgs = append(msgs, fmt.Sprintf("%s: %s", c.Field, c.Message))
+	}
+	return fmt.Sprintf("%s: %s", e.Message, strings.Join(msgs, ", "))
+}
+
+// Add records a cause for field.
+func (e *ValidationError) Add(field, message string) {
+	e.Causes = append(e.Causes, metav1.StatusCause{Type: metav1.CauseTypeFieldValueInvalid, Field: field, Message: message})
+}
+
+// Err returns e when it has causes otherwise nil.
+func (e *ValidationError) Err() error {
+	if len(e.Causes) == 0 {
+		return nil
+	}
+	return e
+}
+
+type ValidationMode int
+
+const (
+	// Enforce denies pods that fail validation.
+	Enforce ValidationMode = iota
+	// Warn allows pods that fail validation returning the failure as a warning.
+	Warn
+)
+
+func (m ValidationMode) String() string {
+	if m == Warn {
+		return "warn"
+	}
+	return "enforce"
+}
+
+func bindValidate(validate PodValidatable, mode ValidationMode) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		podValidate(w, r, validate, mode)
+	}
+}
+
+func podValidate(w http.ResponseWriter, r *http.Request, validate PodValidatable, mode ValidationMode) {
+	review, ok := readRequest(w, r)
+	if !ok {
+		return
+	}
+
+	pod, resp, err := admitPod(route(r), review.Request)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if resp != nil {
+		writeReview(w, r, resp)
+		return
+	}
+
+	resp = &v1.AdmissionResponse{
+		UID:     review.Request.UID,
+		Allowed: true,
+	}
+
+	err = validate(pod)
+	if err != nil && mode == Warn {
+		LevelInfo.Printf("status=warned path=%s err='validate: %v'", r.URL.Path, err)
+		resp.Warnings = []string{denyMessage(review.Request, pod, err)}
+	} else if err != nil {
+		LevelInfo.Printf("status=denied path=%s err='validate: %v'", r.URL.Path, err)
+		resp = deny(review.Request, pod, err)
+	}
+
+	writeReview(w, r, resp)
+}
+
+// deny is the response for a pod that failed validation with err.
+func deny(req *v1.AdmissionRequest, pod *corev1.Pod, err error) *v1.AdmissionResponse {
+	resp := &v1.AdmissionResponse{
+		UID:     req.UID,
+		Allowed: false,
+		Result: &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Message: denyMessage(req, pod, err),
+			Reason:  metav1.StatusReasonForbidden,
+			Code:    http.StatusForbidden,
+		},
+	}
+	var ve *ValidationError
+	if errors.As(err, &ve) && len(ve.Causes) > 0 {
+		resp.Result.Details = &metav1.StatusDetails{Name: pod.Name, Kind: "Pod", Causes: ve.Causes}
+	}
+	return resp
+}
+
+// DenyTemplate renders the message of pods denied by v with tmpl, e.g. "pod {{.Name}} in {{.Namespace}} denied:
+// {{.Message}}", see DenyData for the fields available.
+func DenyTemplate(tmpl *template.Template, v PodValidatable) PodValidatable {
+	return func(pod *corev1.Pod) error {
+		err := v(pod)
+		if err != nil {
+			return &templatedDenial{tmpl: tmpl, err: err}
+		}
+		return nil
+	}
+}
+
+// DenyData is rendered by a DenyTemplate.
+type DenyData struct {
+	// Name is the pod's name or its generateName prefix when the name is yet to be generated.
+	Name string
+	// Namespace is taken from the request as the pod's may be unset on CREATE.
+	Namespace string
+	Operation string
+	User      string
+	// Message is the denial's untemplated message.
+	Message string
+}
+
+// templatedDenial is a denial whose message is rendered with the request by deny.
+type templatedDenial struct {
+	tmpl *template.Template
+	err  error
+}
+
+func (e *templatedDenial) Error() string {
+	return e.err.Error()
+}
+
+func (e *templatedDenial) Unwrap() error {
+	return e.err
+}
+
+// denyMessage is the message for a pod that failed validation with err, rendering the DenyTemplate when there is one.
+// A template that fails to render falls back to the untemplated message.
+func denyMessage(req *v1.AdmissionRequest, pod *corev1.Pod, err error) string {
+	var td *templatedDenial
+	if !errors.As(err, &td) {
+		return err.Error()
+	}
+	name := pod.Name
+	if name == "" {
+		name = pod.GenerateName
+	}
+	data := DenyData{Name: name, Namespace: req.Namespace, Operation: string(req.Operation), User: req.UserInfo.Username, Message: td.err.Error()}
+	var b strings.Builder
+	terr := td.tmpl.Execute(&b, data)
+	if terr != nil {
+		LevelWarn.Printf("status=fallback uid=%s err='deny template: %v'", req.UID, terr)
+		return err.Error()
+	}
+	return b.String()
+}
+
+// ValidateThenReview is Review for routes that both validate and mutate. The pod is denied when validate fails
+// otherwise it's patched by apply, saving a second webhook invocation.
+func ValidateThenReview(req *v1.AdmissionRequest, validate PodValidatable, apply PodPatchable) (*v1.AdmissionResponse, error) {
+	return validateThenReview("", req, validate, apply, DefaultFailPolicy)
+}
+
+// validateThenReview is ValidateThenReview for the route on path failing under the route's policy.
+func validateThenReview(path string, req *v1.AdmissionRequest, validate PodValidatable, apply PodPatchable, policy FailPolicy) (*v1.AdmissionResponse, error) {
+	pod, resp, err := admitPod(path, req)
+	if resp != nil || err != nil {
+		return resp, err
+	}
+
+	err = validate(pod)
+	if err != nil {
+		LevelInfo.Printf("status=denied path=%s err='validate: %v'", path, err)
+		return deny(req, pod, err), nil
+	}
+
+	return patchPod(path, req, pod, "", apply, policy)
+}
+
+func bindValidateThenPatch(validate PodValidatable, apply PodPatchable, policy func() FailPolicy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		review, ok := readRequest(w, r)
+		if !ok {
+			return
+		}
+
+		resp, err := validateThenReview(route(r), review.Request, validate, apply, policy())
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeReview(w, r, resp)
+	}
+}
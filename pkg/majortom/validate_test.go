@@ -0,0 +1,182 @@
+package majortom
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"text/template"
+
+	v1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+var errLatest = fmt.Errorf("image uses :latest")
+
+func forbidAll(*corev1.Pod) error {
+	return errLatest
+}
+
+func permitAll(*corev1.Pod) error {
+	return nil
+}
+
+func validateReview(t *testing.T, v PodValidatable, mode ValidationMode) *v1.AdmissionResponse {
+	r := post(&v1.AdmissionReview{Request: &v1.AdmissionRequest{UID: "abc123", Namespace: "default", Resource: resourcePods, Object: tidePod()}})
+	w := httptest.NewRecorder()
+	podValidate(w, r, v, mode)
+	if w.Code != http.StatusOK {
+		t.Fatalf("w.Code=%v, want StatusOK", w.Code)
+	}
+	var review v1.AdmissionReview
+	err := json.NewDecoder(w.Body).Decode(&review)
+	if err != nil {
+		t.Fatalf("Decode err=%v, want nil", err)
+	}
+	return review.Response
+}
+
+func Test_podValidate_enforce_denies(t *testing.T) {
+	resp := validateReview(t, forbidAll, Enforce)
+	if resp.Allowed {
+		t.Error("resp.Allowed=true, want false")
+	}
+	if resp.Result == nil || resp.Result.Message != errLatest.Error() {
+		t.Errorf("resp.Result=%#v, want message %v", resp.Result, errLatest)
+	}
+	if resp.UID != "abc123" {
+		t.Errorf("resp.UID=%v, want abc123", resp.UID)
+	}
+}
+
+func Test_podValidate_warn_allows_with_warning(t *testing.T) {
+	resp := validateReview(t, forbidAll, Warn)
+	if !resp.Allowed {
+		t.Error("resp.Allowed=false, want true")
+	}
+	if len(resp.Warnings) != 1 || resp.Warnings[0] != errLatest.Error() {
+		t.Errorf("resp.Warnings=%v, want [%v]", resp.Warnings, errLatest)
+	}
+}
+
+func Test_podValidate_passing(t *testing.T) {
+	for _, mode := range []ValidationMode{Enforce, Warn} {
+		resp := validateReview(t, permitAll, mode)
+		if !resp.Allowed {
+			t.Errorf("%v resp.Allowed=false, want true", mode)
+		}
+		if len(resp.Warnings) != 0 {
+			t.Errorf("%v resp.Warnings=%v, want none", mode, resp.Warnings)
+		}
+	}
+}
+
+func Test_podValidate_causes_round_trip(t *testing.T) {
+	multiple := func(*corev1.Pod) error {
+		ve := &ValidationError{Message: "pod violates image policy"}
+		ve.Add("spec.containers[0].image", "uses :latest")
+		ve.Add("spec.containers[1].image", "registry not allowed")
+		return ve.Err()
+	}
+	resp := validateReview(t, multiple, Enforce)
+	if resp.Allowed {
+		t.Error("resp.Allowed=true, want false")
+	}
+	if resp.Result.Details == nil || len(resp.Result.Details.Causes) != 2 {
+		t.Fatalf("resp.Result.Details=%#v, want 2 causes", resp.Result.Details)
+	}
+	cause := resp.Result.Details.Causes[1]
+	if cause.Field != "spec.containers[1].image" || cause.Message != "registry not allowed" {
+		t.Errorf("causes[1]=%#v, want spec.containers[1].image registry not allowed", cause)
+	}
+	expected := "pod violates image policy: spec.containers[0].image: uses :latest, spec.containers[1].image: registry not allowed"
+	if resp.Result.Message != expected {
+		t.Errorf("resp.Result.Message=%v, want %v", resp.Result.Message, expected)
+	}
+}
+
+func Test_ValidationError_Err_without_causes(t *testing.T) {
+	ve := &ValidationError{Message: "pod violates image policy"}
+	if ve.Err() != nil {
+		t.Errorf("ve.Err()=%v, want nil", ve.Err())
+	}
+}
+
+func Test_HandleValidateThenPatch(t *testing.T) {
+	cases := map[string]struct {
+		validate PodValidatable
+		allowed  bool
+		patch    bool
+	}{
+		"validation fails":  {forbidAll, false, false},
+		"validation passes": {permitAll, true, true},
+	}
+
+	for n, tc := range cases {
+		tc := tc
+		t.Run(n, func(t *testing.T) {
+			rt := NewRouter()
+			rt.HandleValidateThenPatch("/validated/owner", tc.validate, AddOwner)
+			r := post(&v1.AdmissionReview{Request: &v1.AdmissionRequest{UID: "abc123", Namespace: "default", Resource: resourcePods, Object: tidePod()}})
+			r.URL.Path = "/validated/owner"
+			w := httptest.NewRecorder()
+			rt.mux.ServeHTTP(w, r)
+			if w.Code != http.StatusOK {
+				t.Fatalf("w.Code=%v, want StatusOK", w.Code)
+			}
+			resp := decodeResponse(t, w)
+			if resp.Allowed != tc.allowed {
+				t.Errorf("resp.Allowed=%v, want %v", resp.Allowed, tc.allowed)
+			}
+			actual := resp.Patch != nil
+			if actual != tc.patch {
+				t.Errorf("patched=%v, want %v", actual, tc.patch)
+			}
+			if !tc.allowed && (resp.Result == nil || resp.Result.Message != errLatest.Error()) {
+				t.Errorf("resp.Result=%#v, want message %v", resp.Result, errLatest)
+			}
+		})
+	}
+}
+
+func Test_DenyTemplate(t *testing.T) {
+	tmpl := template.Must(template.New("deny").Parse("pod {{.Name}} in {{.Namespace}} denied: {{.Message}}"))
+	cases := map[string]struct {
+		tmpl *template.Template
+		want string
+	}{
+		"rendered":       {tmpl, "pod tide-7f9c in default denied: image uses :latest"},
+		"render failure": {template.Must(template.New("deny").Parse("{{.Missing}}")), "image uses :latest"},
+	}
+
+	for n, tc := range cases {
+		tc := tc
+		t.Run(n, func(t *testing.T) {
+			raw, _ := json.Marshal(&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "tide-7f9c"}})
+			req := &v1.AdmissionRequest{UID: "abc123", Namespace: "default", Resource: resourcePods, Object: runtime.RawExtension{Raw: raw}}
+			resp, err := ValidateThenReview(req, DenyTemplate(tc.tmpl, forbidAll), AddOwner)
+			if err != nil {
+				t.Fatalf("err=%v, want nil", err)
+			}
+			if resp.Allowed {
+				t.Fatal("resp.Allowed=true, want false")
+			}
+			if resp.Result.Message != tc.want {
+				t.Errorf("resp.Result.Message=%q, want %q", resp.Result.Message, tc.want)
+			}
+		})
+	}
+}
+
+func Test_DenyTemplate_generate_name(t *testing.T) {
+	tmpl := template.Must(template.New("deny").Parse("pod {{.Name}} denied"))
+	req := &v1.AdmissionRequest{Namespace: "default"}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{GenerateName: "tide-"}}
+	actual := denyMessage(req, pod, DenyTemplate(tmpl, forbidAll)(pod))
+	if actual != "pod tide- denied" {
+		t.Errorf("denyMessage()=%q, want %q", actual, "pod tide- denied")
+	}
+}
@@ -0,0 +1,346 @@
+package majortom
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// VarOption customises the behaviour of VarPatch.
+type VarOption func(*varConfig)
+
+type varConfig struct {
+	selectors     []containerSelector
+	record        bool
+	envNamePrefix string
+	apiVersion    string
+	prepend       bool
+}
+
+// containerSelector reports whether the container at index i of n containers should be patched.
+type containerSelector func(i, n int, container *corev1.Container) bool
+
+func newVarConfig(opts []VarOption) *varConfig {
+	cfg := &varConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return cfg
+}
+
+func (cfg *varConfig) selected(i, n int, container *corev1.Container) bool {
+	for _, sel := range cfg.selectors {
+		if !sel(i, n, container) {
+			return false
+		}
+	}
+	return true
+}
+
+// InContainers restricts patching to containers with an index between from and to inclusive.
+func InContainers(from, to int) VarOption {
+	return func(cfg *varConfig) {
+		cfg.selectors = append(cfg.selectors, func(i, _ int, _ *corev1.Container) bool {
+			return i >= from && i <= to
+		})
+	}
+}
+
+// InContainer restricts patching to the container at index i.
+func InContainer(i int) VarOption {
+	return InContainers(i, i)
+}
+
+// InLastContainer restricts patching to the last container, typically the main workload when sidecars are prepended.
+// Pods without containers aren't patched.
+func InLastContainer() VarOption {
+	return func(cfg *varConfig) {
+		cfg.selectors = append(cfg.selectors, func(i, n int, _ *corev1.Container) bool {
+			return i == n-1
+		})
+	}
+}
+
+// InjectMarker is the env var a container declares with the value "true" to opt in to injection with Marked.
+const InjectMarker = "MAJORTOM_INJECT"
+
+// Marked restricts patching to containers that declare the InjectMarker env var as "true".
+func Marked() VarOption {
+	return func(cfg *varConfig) {
+		cfg.selectors = append(cfg.selectors, func(_, _ int, container *corev1.Container) bool {
+			for _, env := range container.Env {
+				if env.Name == InjectMarker {
+					return env.Value == "true"
+				}
+			}
+			return false
+		})
+	}
+}
+
+// AppendStyle determines how env var patchables reference the end of an existing env array.
+type AppendStyle int
+
+const (
+	// AppendIndex adds at the numeric index one past the last entry.
+	AppendIndex AppendStyle = iota
+	// AppendDash adds at the - token which RFC 6902 defines as the end of the array.
+	AppendDash
+)
+
+var appendStyleNames = []string{"index", "dash"}
+
+// EnvAppendStyle is used by the env var patchables when appending to an existing env.
+var EnvAppendStyle = AppendIndex
+
+func (s AppendStyle) String() string {
+	if s < AppendIndex || s > AppendDash {
+		return fmt.Sprintf("appendstyle(%d)", int(s))
+	}
+	return appendStyleNames[s]
+}
+
+// Set parses the style name v for use as a flag.Value.
+func (s *AppendStyle) Set(v string) error {
+	for i, name := range appendStyleNames {
+		if strings.EqualFold(v, name) {
+			*s = AppendStyle(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown append style %q, want one of %s", v, strings.Join(appendStyleNames, ", "))
+}
+
+// path references the element after the last of the array at base, i being the array length.
+func (s AppendStyle) path(base string, i int) string {
+	if s == AppendDash {
+		return base + "/-"
+	}
+	return fmt.Sprintf("%s/%d", base, i)
+}
+
+// InjectedVarsAnnotation records the comma separated names of the vars majortom injected into a pod.
+const InjectedVarsAnnotation = "majortom.io/injected-vars"
+
+// RecordInjected records injected var names in the InjectedVarsAnnotation and skips names already recorded. It
+// prevents re-injection when a controller persisted previously injected env into its pod template.
+func RecordInjected() VarOption {
+	return func(cfg *varConfig) {
+		cfg.record = true
+	}
+}
+
+// injectedVars returns the var names recorded in the pod's InjectedVarsAnnotation.
+func injectedVars(pod *corev1.Pod) stringSet {
+	recorded := stringSet{}
+	v, ok := pod.ObjectMeta.Annotations[InjectedVarsAnnotation]
+	if ok {
+		_ = recorded.Set(v)
+	}
+	return recorded
+}
+
+// SetEnvPatch replaces the complete env of the named container with env.
+func SetEnvPatch(containerName string, env []corev1.EnvVar) PodPatchable {
+	return func(pod *corev1.Pod) ([]operation, error) {
+		for i, container := range pod.Spec.Containers {
+			if container.Name != containerName {
+				continue
+			}
+			if reflect.DeepEqual(container.Env, env) {
+				return nil, nil
+			}
+			path := fmt.Sprintf("/spec/containers/%d/env", i)
+			if container.Env == nil {
+				return []operation{addOp(path, env)}, nil
+			}
+			return []operation{replaceOp(path, env)}, nil
+		}
+		return nil, nil
+	}
+}
+
+// WithNamePrefix restricts patching to containers whose name starts with prefix.
+func WithNamePrefix(prefix string) VarOption {
+	return func(cfg *varConfig) {
+		cfg.selectors = append(cfg.selectors, func(_, _ int, container *corev1.Container) bool {
+			return strings.HasPrefix(container.Name, prefix)
+		})
+	}
+}
+
+// WithImage restricts patching to containers whose image matches pattern.
+func WithImage(pattern *regexp.Regexp) VarOption {
+	return func(cfg *varConfig) {
+		cfg.selectors = append(cfg.selectors, func(_, _ int, container *corev1.Container) bool {
+			return pattern.MatchString(container.Image)
+		})
+	}
+}
+
+// ImageEnv is the env injected into containers whose image matches Pattern, e.g. JAVA_OPTS for openjdk images.
+type ImageEnv struct {
+	Pattern *regexp.Regexp
+	// Env maps env var names to their literal values.
+	Env map[string]string
+}
+
+// ImageEnvPatch injects the env of every rule matching a container's image. A container matching several rules gets
+// the env of each, later rules replacing the values of earlier ones.
+func ImageEnvPatch(rules ...ImageEnv) PodPatchable {
+	var ps []PodPatchable
+	for _, rule := range rules {
+		names := make([]string, 0, len(rule.Env))
+		for name := range rule.Env {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			ps = append(ps, EnvPatch(corev1.EnvVar{Name: name, Value: rule.Env[name]}, WithImage(rule.Pattern)))
+		}
+	}
+	return Chain(ps...)
+}
+
+// WithEnvNamePrefix prepends prefix (e.g. MT_) to the name of every env var injected, namespacing them from the app's
+// own. The prefixed names must be C identifiers.
+func WithEnvNamePrefix(prefix string) VarOption {
+	return func(cfg *varConfig) {
+		cfg.envNamePrefix = prefix
+	}
+}
+
+// WithFieldAPIVersion sets the apiVersion of the fieldRef of vars injected by VarsPatch, without it the apiserver
+// defaults it to v1.
+func WithFieldAPIVersion(apiVersion string) VarOption {
+	return func(cfg *varConfig) {
+		cfg.apiVersion = apiVersion
+	}
+}
+
+// Prepend inserts injected vars ahead of the container's existing env rather than appending them so the app's own
+// vars can reference them with $(NAME). Vars the container already defines are replaced in place.
+func Prepend() VarOption {
+	return func(cfg *varConfig) {
+		cfg.prepend = true
+	}
+}
+
+// varName is name with the configured prefix, validated when there is a prefix.
+func (cfg *varConfig) varName(name string) (string, error) {
+	if cfg.envNamePrefix == "" {
+		return name, nil
+	}
+	prefixed := cfg.envNamePrefix + name
+	errs := validation.IsCIdentifier(prefixed)
+	if len(errs) > 0 {
+		return "", fmt.Errorf("var %s: %s", prefixed, strings.Join(errs, "; "))
+	}
+	return prefixed, nil
+}
+
+// EnvPatch adds env to, or replaces an env var of the same name in, each selected container.
+func EnvPatch(env corev1.EnvVar, opts ...VarOption) PodPatchable {
+	cfg := newVarConfig(opts)
+	name, nameErr := cfg.varName(env.Name)
+	env.Name = name
+	return func(pod *corev1.Pod) ([]operation, error) {
+		if nameErr != nil {
+			return nil, nameErr
+		}
+		var ops []operation
+		for i := range pod.Spec.Containers {
+			container := pod.Spec.Containers[i]
+			if !cfg.selected(i, len(pod.Spec.Containers), &container) {
+				continue
+			}
+			op := envOp(i, container.Env, env)
+			if cfg.prepend && op.Op == "add" && len(container.Env) > 0 {
+				op.Path = fmt.Sprintf("/spec/containers/%d/env/0", i)
+			}
+			ops = append(ops, op)
+		}
+		return ops, nil
+	}
+}
+
+func envOp(i int, existing []corev1.EnvVar, env corev1.EnvVar) operation {
+	for j := range existing {
+		if existing[j].Name == env.Name {
+			return replaceOp(fmt.Sprintf("/spec/containers/%d/env/%d", i, j), env)
+		}
+	}
+	if len(existing) == 0 {
+		return addOp(fmt.Sprintf("/spec/containers/%d/env", i), []corev1.EnvVar{env})
+	}
+	return addOp(EnvAppendStyle.path(fmt.Sprintf("/spec/containers/%d/env", i), len(existing)), env)
+}
+
+// SecretVarPatch injects the env var name referencing key of the named secret. When optional the pod starts even
+// if the secret or key is missing.
+func SecretVarPatch(name, secret, key string, optional bool, opts ...VarOption) PodPatchable {
+	return EnvPatch(corev1.EnvVar{
+		Name: name,
+		ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: secret},
+			Key:                  key,
+			Optional:             &optional,
+		}},
+	}, opts...)
+}
+
+// ConfigMapVarPatch injects the env var name referencing key of the named config map. When optional the pod starts
+// even if the config map or key is missing.
+func ConfigMapVarPatch(name, configMap, key string, optional bool, opts ...VarOption) PodPatchable {
+	return EnvPatch(corev1.EnvVar{
+		Name: name,
+		ValueFrom: &corev1.EnvVarSource{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: configMap},
+			Key:                  key,
+			Optional:             &optional,
+		}},
+	}, opts...)
+}
+
+// ErrContainerNotFound is returned when a patchable targets a container the pod doesn't have.
+var ErrContainerNotFound = fmt.Errorf("container not found")
+
+// resourceFields are the container resources exposed through the downward API resourceFieldRef.
+var resourceFields = map[string]bool{
+	"limits.cpu":                 true,
+	"limits.memory":              true,
+	"limits.ephemeral-storage":   true,
+	"requests.cpu":               true,
+	"requests.memory":            true,
+	"requests.ephemeral-storage": true,
+}
+
+// ResourceFieldVarPatch injects the env var name referencing resource (e.g. limits.memory) of the named container.
+// Resources are per container so the container is resolved by name and ErrContainerNotFound is returned when the pod
+// doesn't have it.
+func ResourceFieldVarPatch(name, containerName, resource string) PodPatchable {
+	return func(pod *corev1.Pod) ([]operation, error) {
+		if !resourceFields[resource] {
+			return nil, fmt.Errorf("resource %q: %w", resource, ErrInvalidFieldPath)
+		}
+		for i, container := range pod.Spec.Containers {
+			if container.Name != containerName {
+				continue
+			}
+			env := corev1.EnvVar{
+				Name: name,
+				ValueFrom: &corev1.EnvVarSource{ResourceFieldRef: &corev1.ResourceFieldSelector{
+					ContainerName: containerName,
+					Resource:      resource,
+				}},
+			}
+			return []operation{envOp(i, container.Env, env)}, nil
+		}
+		return nil, fmt.Errorf("%w: %s", ErrContainerNotFound, containerName)
+	}
+}
@@ -0,0 +1,640 @@
+package majortom
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func threeContainerPod() *corev1.Pod {
+	return &corev1.Pod{
+		Spec: corev1.PodSpec{Containers: []corev1.Container{
+			{Name: "app", Image: "nginx:latest"},
+			{Name: "sidecar-log", Image: "fluentd:latest"},
+			{Name: "sidecar-metrics", Image: "statsd:latest"},
+		}},
+	}
+}
+
+func Test_VarPatch_InContainer_only_first(t *testing.T) {
+	pod := threeContainerPod()
+	ops, err := VarPatch("NODEIP", "status.hostIP", InContainer(0))(pod)
+	if err != nil {
+		t.Errorf("err=%v, want nil", err)
+	}
+	if len(ops) != 1 {
+		t.Fatalf("len(ops)=%v, want 1", len(ops))
+	}
+	patched := applyPatch(t, pod, ops)
+	for i, c := range patched.Spec.Containers {
+		expected := 0
+		if i == 0 {
+			expected = 1
+		}
+		if len(c.Env) != expected {
+			t.Errorf("len(containers[%d].env)=%v, want %v", i, len(c.Env), expected)
+		}
+	}
+}
+
+func Test_VarPatch_InLastContainer_only_last(t *testing.T) {
+	pod := threeContainerPod()
+	ops, err := VarPatch("NODEIP", "status.hostIP", InLastContainer())(pod)
+	if err != nil {
+		t.Errorf("err=%v, want nil", err)
+	}
+	patched := applyPatch(t, pod, ops)
+	for i, c := range patched.Spec.Containers {
+		expected := 0
+		if i == 2 {
+			expected = 1
+		}
+		if len(c.Env) != expected {
+			t.Errorf("len(containers[%d].env)=%v, want %v", i, len(c.Env), expected)
+		}
+	}
+}
+
+func Test_VarPatch_InLastContainer_without_containers(t *testing.T) {
+	ops, err := VarPatch("NODEIP", "status.hostIP", InLastContainer())(&corev1.Pod{})
+	if err != nil {
+		t.Errorf("err=%v, want nil", err)
+	}
+	if len(ops) != 0 {
+		t.Errorf("len(ops)=%v, want 0", len(ops))
+	}
+}
+
+func Test_VarPatch_InContainers_range(t *testing.T) {
+	ops, err := VarPatch("NODEIP", "status.hostIP", InContainers(1, 5))(threeContainerPod())
+	if err != nil {
+		t.Errorf("err=%v, want nil", err)
+	}
+	if len(ops) != 2 {
+		t.Fatalf("len(ops)=%v, want 2", len(ops))
+	}
+	if ops[0].Path != "/spec/containers/1/env" {
+		t.Errorf("ops[0].Path=%v, want /spec/containers/1/env", ops[0].Path)
+	}
+}
+
+func Test_VarPatch_Marked_only_injects_marked_container(t *testing.T) {
+	pod := &corev1.Pod{
+		Spec: corev1.PodSpec{Containers: []corev1.Container{
+			{Name: "app", Image: "nginx:latest"},
+			{Name: "marked", Image: "nginx:latest", Env: []corev1.EnvVar{{Name: InjectMarker, Value: "true"}}},
+			{Name: "unmarked", Image: "nginx:latest", Env: []corev1.EnvVar{{Name: InjectMarker, Value: "false"}}},
+		}},
+	}
+	ops, err := VarPatch("NODEIP", "status.hostIP", Marked())(pod)
+	if err != nil {
+		t.Errorf("err=%v, want nil", err)
+	}
+	if len(ops) != 1 {
+		t.Fatalf("len(ops)=%v, want 1", len(ops))
+	}
+	if ops[0].Path != "/spec/containers/1/env/1" {
+		t.Errorf("ops[0].Path=%v, want /spec/containers/1/env/1", ops[0].Path)
+	}
+}
+
+func Test_SetEnvPatch(t *testing.T) {
+	desired := []corev1.EnvVar{{Name: "REMOTE", Value: "junctionbox.ca"}, {Name: "PORT", Value: "8080"}}
+	cases := map[string]struct {
+		env []corev1.EnvVar
+		op  string
+	}{
+		"nil env":      {nil, "add"},
+		"existing env": {[]corev1.EnvVar{{Name: "REMOTE", Value: "localhost"}, {Name: "DEBUG", Value: "true"}}, "replace"},
+	}
+
+	for n, tc := range cases {
+		tc := tc
+		t.Run(n, func(t *testing.T) {
+			pod := threeContainerPod()
+			pod.Spec.Containers[1].Env = tc.env
+			ops, err := SetEnvPatch("sidecar-log", desired)(pod)
+			if err != nil {
+				t.Fatalf("err=%v, want nil", err)
+			}
+			if len(ops) != 1 || ops[0].Op != tc.op || ops[0].Path != "/spec/containers/1/env" {
+				t.Fatalf("ops=%#v, want single %s at /spec/containers/1/env", ops, tc.op)
+			}
+			patched := applyPatch(t, pod, ops)
+			if !cmp.Equal(patched.Spec.Containers[1].Env, desired) {
+				t.Errorf("env mismatch (+want -got)\n%s", cmp.Diff(patched.Spec.Containers[1].Env, desired))
+			}
+		})
+	}
+}
+
+func Test_SetEnvPatch_unchanged_or_missing(t *testing.T) {
+	desired := []corev1.EnvVar{{Name: "REMOTE", Value: "junctionbox.ca"}}
+	pod := threeContainerPod()
+	pod.Spec.Containers[0].Env = desired
+	for _, name := range []string{"app", "missing"} {
+		ops, err := SetEnvPatch(name, desired)(pod)
+		if err != nil {
+			t.Errorf("%s err=%v, want nil", name, err)
+		}
+		if len(ops) != 0 {
+			t.Errorf("%s len(ops)=%v, want 0", name, len(ops))
+		}
+	}
+}
+
+func Test_VarsPatch_multiple_vars(t *testing.T) {
+	pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{
+		{Image: "nginx:latest"},
+		{Image: "nginx:latest", Env: []corev1.EnvVar{{Name: "REMOTE", Value: "junctionbox.ca"}, {Name: "PODIP", Value: "localhost"}}},
+	}}}
+	ops, err := VarsPatch(map[string]string{"NODEIP": "status.hostIP", "PODIP": "status.podIP", "NODENAME": "spec.nodeName"})(pod)
+	if err != nil {
+		t.Fatalf("err=%v, want nil", err)
+	}
+	patched := applyPatch(t, pod, ops)
+	expected := [][]string{
+		{"NODEIP", "NODENAME", "PODIP"},
+		{"REMOTE", "PODIP", "NODEIP", "NODENAME"},
+	}
+	for i, c := range patched.Spec.Containers {
+		var names []string
+		for _, env := range c.Env {
+			names = append(names, env.Name)
+		}
+		if !cmp.Equal(names, expected[i]) {
+			t.Errorf("containers[%d] env mismatch (+want -got)\n%s", i, cmp.Diff(names, expected[i]))
+		}
+	}
+	if patched.Spec.Containers[1].Env[1].ValueFrom.FieldRef.FieldPath != "status.podIP" {
+		t.Errorf("containers[1].env[1]=%#v, want replaced with status.podIP", patched.Spec.Containers[1].Env[1])
+	}
+}
+
+func Test_VarsPatch_removes_duplicate_names(t *testing.T) {
+	pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{
+		{Image: "nginx:latest", Env: []corev1.EnvVar{
+			{Name: "NODEIP", Value: "10.0.0.1"},
+			{Name: "REMOTE", Value: "junctionbox.ca"},
+			{Name: "NODEIP", Value: "10.0.0.2"},
+			{Name: "PODIP", Value: "localhost"},
+		}},
+	}}}
+	ops, err := VarsPatch(map[string]string{"NODEIP": "status.hostIP", "PODIP": "status.podIP", "NODENAME": "spec.nodeName"})(pod)
+	if err != nil {
+		t.Fatalf("err=%v, want nil", err)
+	}
+	patched := applyPatch(t, pod, ops)
+	var names []string
+	for _, env := range patched.Spec.Containers[0].Env {
+		names = append(names, env.Name)
+		if env.Name == "PODIP" && env.ValueFrom.FieldRef.FieldPath != "status.podIP" {
+			t.Errorf("PODIP=%#v, want replaced with status.podIP", env)
+		}
+	}
+	expected := []string{"NODEIP", "REMOTE", "PODIP", "NODENAME"}
+	if !cmp.Equal(names, expected) {
+		t.Errorf("env mismatch (+want -got)\n%s", cmp.Diff(names, expected))
+	}
+}
+
+func benchmarkPod(containers, envs int) *corev1.Pod {
+	pod := &corev1.Pod{}
+	for i := 0; i < containers; i++ {
+		c := corev1.Container{Name: fmt.Sprintf("c%d", i), Image: "nginx:latest"}
+		for j := 0; j < envs; j++ {
+			c.Env = append(c.Env, corev1.EnvVar{Name: fmt.Sprintf("ENV_%d", j), Value: "x"})
+		}
+		pod.Spec.Containers = append(pod.Spec.Containers, c)
+	}
+	return pod
+}
+
+func benchmarkVars(k int) map[string]string {
+	vars := map[string]string{}
+	for i := 0; i < k; i++ {
+		vars[fmt.Sprintf("INJECTED_%d", i)] = "status.hostIP"
+	}
+	return vars
+}
+
+// nestedScanVarsPatch is the previous approach of scanning each container's env once per var, kept to compare
+// against Benchmark_VarsPatch.
+func nestedScanVarsPatch(vars map[string]string) PodPatchable {
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return func(pod *corev1.Pod) ([]operation, error) {
+		var ops []operation
+		for _, name := range names {
+			for i := range pod.Spec.Containers {
+				container := pod.Spec.Containers[i]
+				var op operation
+				var wasFound = false
+				for j := range container.Env {
+					if container.Env[j].Name == name {
+						wasFound = true
+						op = varReplace(i, j, name, vars[name])
+						break
+					}
+				}
+				if !wasFound {
+					op = varAdd(i, len(container.Env), name, vars[name])
+				}
+				ops = append(ops, op)
+			}
+		}
+		return ops, nil
+	}
+}
+
+func Benchmark_VarsPatch(b *testing.B) {
+	pod := benchmarkPod(20, 200)
+	p := VarsPatch(benchmarkVars(50))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = p(pod)
+	}
+}
+
+func Benchmark_VarsPatch_nested_scan(b *testing.B) {
+	pod := benchmarkPod(20, 200)
+	p := nestedScanVarsPatch(benchmarkVars(50))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = p(pod)
+	}
+}
+
+func Test_VarPatch_WithNamePrefix(t *testing.T) {
+	pod := threeContainerPod()
+	ops, err := VarPatch("NODEIP", "status.hostIP", WithNamePrefix("sidecar-"))(pod)
+	if err != nil {
+		t.Errorf("err=%v, want nil", err)
+	}
+	var paths []string
+	for _, op := range ops {
+		paths = append(paths, op.Path)
+	}
+	expected := []string{"/spec/containers/1/env", "/spec/containers/2/env"}
+	if !cmp.Equal(paths, expected) {
+		t.Errorf("paths mismatch (+want -got)\n%s", cmp.Diff(paths, expected))
+	}
+	patched := applyPatch(t, pod, ops)
+	if len(patched.Spec.Containers[0].Env) != 0 {
+		t.Errorf("len(containers[0].env)=%v, want 0", len(patched.Spec.Containers[0].Env))
+	}
+}
+
+func Test_SecretVarPatch_optional(t *testing.T) {
+	for _, optional := range []bool{true, false} {
+		pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Image: "nginx:latest", Env: []corev1.EnvVar{{Name: "REMOTE", Value: "junctionbox.ca"}}}}}}
+		ops, err := SecretVarPatch("API_TOKEN", "api", "token", optional)(pod)
+		if err != nil {
+			t.Fatalf("err=%v, want nil", err)
+		}
+		patched := applyPatch(t, pod, ops)
+		env := patched.Spec.Containers[0].Env
+		if len(env) != 2 || env[1].ValueFrom == nil || env[1].ValueFrom.SecretKeyRef == nil {
+			t.Fatalf("env=%#v, want API_TOKEN from secret", env)
+		}
+		ref := env[1].ValueFrom.SecretKeyRef
+		if ref.Name != "api" || ref.Key != "token" {
+			t.Errorf("secretKeyRef=%s/%s, want api/token", ref.Name, ref.Key)
+		}
+		if ref.Optional == nil || *ref.Optional != optional {
+			t.Errorf("secretKeyRef.Optional=%v, want %v", ref.Optional, optional)
+		}
+	}
+}
+
+func Test_ConfigMapVarPatch_replaces_existing(t *testing.T) {
+	pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Image: "nginx:latest", Env: []corev1.EnvVar{{Name: "LOG_LEVEL", Value: "debug"}}}}}}
+	ops, err := ConfigMapVarPatch("LOG_LEVEL", "logging", "level", true)(pod)
+	if err != nil {
+		t.Fatalf("err=%v, want nil", err)
+	}
+	patched := applyPatch(t, pod, ops)
+	env := patched.Spec.Containers[0].Env
+	if len(env) != 1 || env[0].Value != "" || env[0].ValueFrom.ConfigMapKeyRef == nil {
+		t.Fatalf("env=%#v, want LOG_LEVEL replaced with config map ref", env)
+	}
+	if *env[0].ValueFrom.ConfigMapKeyRef.Optional != true {
+		t.Error("configMapKeyRef.Optional=false, want true")
+	}
+}
+
+func Test_VarsPatch_RecordInjected_skips_recorded_vars(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{InjectedVarsAnnotation: "NODEIP"}},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{
+			{Image: "nginx:latest", Env: []corev1.EnvVar{{Name: "NODEIP", Value: "10.0.0.1"}}},
+		}},
+	}
+	ops, err := VarsPatch(map[string]string{"NODEIP": "status.hostIP", "PODIP": "status.podIP"}, RecordInjected())(pod)
+	if err != nil {
+		t.Fatalf("err=%v, want nil", err)
+	}
+	patched := applyPatch(t, pod, ops)
+	env := patched.Spec.Containers[0].Env
+	if len(env) != 2 || env[0].Value != "10.0.0.1" || env[1].Name != "PODIP" {
+		t.Errorf("env=%v, want NODEIP untouched and PODIP added", env)
+	}
+	if patched.Annotations[InjectedVarsAnnotation] != "NODEIP,PODIP" {
+		t.Errorf("annotations[%s]=%v, want NODEIP,PODIP", InjectedVarsAnnotation, patched.Annotations[InjectedVarsAnnotation])
+	}
+}
+
+func Test_VarsPatch_RecordInjected_creates_marker(t *testing.T) {
+	pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Image: "nginx:latest"}}}}
+	ops, err := VarPatch("NODEIP", "status.hostIP", RecordInjected())(pod)
+	if err != nil {
+		t.Fatalf("err=%v, want nil", err)
+	}
+	patched := applyPatch(t, pod, ops)
+	if patched.Annotations[InjectedVarsAnnotation] != "NODEIP" {
+		t.Errorf("annotations=%v, want %s=NODEIP", patched.Annotations, InjectedVarsAnnotation)
+	}
+
+	ops, err = VarPatch("NODEIP", "status.hostIP", RecordInjected())(patched)
+	if err != nil {
+		t.Fatalf("err=%v, want nil", err)
+	}
+	if len(ops) != 0 {
+		t.Errorf("ops=%v, want none once recorded", ops)
+	}
+}
+
+func Test_NodeNameVarPatch(t *testing.T) {
+	pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Image: "nginx:latest"}}}}
+	ops, err := NodeNameVarPatch("NODENAME")(pod)
+	if err != nil {
+		t.Fatalf("err=%v, want nil", err)
+	}
+	patched := applyPatch(t, pod, ops)
+	env := patched.Spec.Containers[0].Env
+	if len(env) != 1 || env[0].Name != "NODENAME" || env[0].ValueFrom.FieldRef.FieldPath != "spec.nodeName" {
+		t.Errorf("env=%#v, want NODENAME from spec.nodeName", env)
+	}
+}
+
+func Test_ResourceFieldVarPatch(t *testing.T) {
+	pod := threeContainerPod()
+	ops, err := ResourceFieldVarPatch("MEMORY_LIMIT", "sidecar-log", "limits.memory")(pod)
+	if err != nil {
+		t.Fatalf("err=%v, want nil", err)
+	}
+	if len(ops) != 1 || ops[0].Path != "/spec/containers/1/env" {
+		t.Fatalf("ops=%v, want single add to /spec/containers/1/env", ops)
+	}
+	patched := applyPatch(t, pod, ops)
+	env := patched.Spec.Containers[1].Env
+	if len(env) != 1 || env[0].ValueFrom.ResourceFieldRef == nil {
+		t.Fatalf("env=%#v, want MEMORY_LIMIT from resourceFieldRef", env)
+	}
+	ref := env[0].ValueFrom.ResourceFieldRef
+	if ref.ContainerName != "sidecar-log" || ref.Resource != "limits.memory" {
+		t.Errorf("resourceFieldRef=%s/%s, want sidecar-log/limits.memory", ref.ContainerName, ref.Resource)
+	}
+}
+
+func Test_ResourceFieldVarPatch_errors(t *testing.T) {
+	cases := map[string]struct {
+		container string
+		resource  string
+		err       error
+	}{
+		"missing container": {"sidecar-trace", "limits.memory", ErrContainerNotFound},
+		"invalid resource":  {"app", "limits.gpu", ErrInvalidFieldPath},
+	}
+
+	for n, tc := range cases {
+		tc := tc
+		t.Run(n, func(t *testing.T) {
+			_, err := ResourceFieldVarPatch("LIMIT", tc.container, tc.resource)(threeContainerPod())
+			if !errors.Is(err, tc.err) {
+				t.Errorf("err=%v, want %v", err, tc.err)
+			}
+		})
+	}
+}
+
+func Test_EnvAppendStyle(t *testing.T) {
+	cases := map[string]struct {
+		style AppendStyle
+		path  string
+	}{
+		"index": {AppendIndex, "/spec/containers/0/env/1"},
+		"dash":  {AppendDash, "/spec/containers/0/env/-"},
+	}
+
+	for n, tc := range cases {
+		tc := tc
+		t.Run(n, func(t *testing.T) {
+			EnvAppendStyle = tc.style
+			defer func() { EnvAppendStyle = AppendIndex }()
+			pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{
+				{Image: "nginx:latest", Env: []corev1.EnvVar{{Name: "REMOTE", Value: "junctionbox.ca"}}},
+			}}}
+			ops, err := VarsPatch(map[string]string{"NODEIP": "status.hostIP", "PODIP": "status.podIP"})(pod)
+			if err != nil {
+				t.Fatalf("err=%v, want nil", err)
+			}
+			if len(ops) != 2 || ops[0].Path != tc.path {
+				t.Errorf("ops=%v, want first add at %v", ops, tc.path)
+			}
+			patched := applyPatch(t, pod, ops)
+			var names []string
+			for _, env := range patched.Spec.Containers[0].Env {
+				names = append(names, env.Name)
+			}
+			expected := []string{"REMOTE", "NODEIP", "PODIP"}
+			if !cmp.Equal(names, expected) {
+				t.Errorf("env mismatch (+want -got)\n%s", cmp.Diff(names, expected))
+			}
+		})
+	}
+}
+
+func Test_AppendStyle_Set(t *testing.T) {
+	var s AppendStyle
+	err := s.Set("Dash")
+	if err != nil || s != AppendDash {
+		t.Errorf("Set(Dash)=%v %v, want dash nil", s, err)
+	}
+	err = s.Set("tail")
+	if err == nil {
+		t.Error("Set(tail) err=nil, want error")
+	}
+}
+
+func Test_VarPatch_leaves_exec_probe_referencing_var(t *testing.T) {
+	probe := &corev1.Probe{Handler: corev1.Handler{Exec: &corev1.ExecAction{Command: []string{"sh", "-c", "wget -qO- http://$NODEIP:10255/healthz"}}}}
+	pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{
+		{Image: "nginx:latest", LivenessProbe: probe, Env: []corev1.EnvVar{{Name: "REMOTE", Value: "junctionbox.ca"}}},
+	}}}
+	ops, err := VarPatch("NODEIP", "status.hostIP")(pod)
+	if err != nil {
+		t.Fatalf("err=%v, want nil", err)
+	}
+	patched := applyPatch(t, pod, ops)
+	c := patched.Spec.Containers[0]
+	if !cmp.Equal(c.LivenessProbe, probe) {
+		t.Errorf("livenessProbe mismatch (+want -got)\n%s", cmp.Diff(c.LivenessProbe, probe))
+	}
+	if len(c.Env) != 2 || c.Env[1].Name != "NODEIP" {
+		t.Errorf("env=%v, want NODEIP appended", c.Env)
+	}
+}
+
+func Test_WithEnvNamePrefix(t *testing.T) {
+	pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}}}
+	cases := map[string]struct {
+		patchable PodPatchable
+		name      string
+	}{
+		"VarPatch":  {VarPatch("NODEIP", "status.hostIP", WithEnvNamePrefix("MT_")), "MT_NODEIP"},
+		"EnvPatch":  {EnvPatch(corev1.EnvVar{Name: "REGION", Value: "eu-west-1"}, WithEnvNamePrefix("MT_")), "MT_REGION"},
+		"no prefix": {VarPatch("NODEIP", "status.hostIP"), "NODEIP"},
+	}
+
+	for n, tc := range cases {
+		tc := tc
+		t.Run(n, func(t *testing.T) {
+			ops, err := tc.patchable(pod)
+			if err != nil {
+				t.Fatalf("err=%v, want nil", err)
+			}
+			if len(ops) != 1 {
+				t.Fatalf("len(ops)=%v, want 1", len(ops))
+			}
+			patched := applyPatch(t, pod, ops)
+			if patched.Spec.Containers[0].Env[0].Name != tc.name {
+				t.Errorf("env[0].name=%v, want %v", patched.Spec.Containers[0].Env[0].Name, tc.name)
+			}
+		})
+	}
+}
+
+func Test_WithEnvNamePrefix_invalid(t *testing.T) {
+	_, err := VarPatch("NODEIP", "status.hostIP", WithEnvNamePrefix("mt-"))(threeContainerPod())
+	if err == nil {
+		t.Error("err=nil, want error")
+	}
+}
+
+func Test_WithFieldAPIVersion(t *testing.T) {
+	cases := map[string]struct {
+		env        []corev1.EnvVar
+		apiVersion string
+	}{
+		"add":       {nil, "v1"},
+		"append":    {[]corev1.EnvVar{{Name: "LOG_LEVEL", Value: "info"}}, "v1"},
+		"replace":   {[]corev1.EnvVar{{Name: "NODEIP", Value: "127.0.0.1"}}, "v1"},
+		"defaulted": {nil, ""},
+	}
+
+	for n, tc := range cases {
+		tc := tc
+		t.Run(n, func(t *testing.T) {
+			pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Env: tc.env}}}}
+			var opts []VarOption
+			if tc.apiVersion != "" {
+				opts = append(opts, WithFieldAPIVersion(tc.apiVersion))
+			}
+			ops, err := VarPatch("NODEIP", "status.hostIP", opts...)(pod)
+			if err != nil {
+				t.Fatalf("err=%v, want nil", err)
+			}
+			patched := applyPatch(t, pod, ops)
+			env := patched.Spec.Containers[0].Env[len(patched.Spec.Containers[0].Env)-1]
+			if env.ValueFrom == nil || env.ValueFrom.FieldRef == nil {
+				t.Fatalf("env=%#v, want fieldRef", env)
+			}
+			if env.ValueFrom.FieldRef.APIVersion != tc.apiVersion {
+				t.Errorf("fieldRef.apiVersion=%v, want %v", env.ValueFrom.FieldRef.APIVersion, tc.apiVersion)
+			}
+		})
+	}
+}
+
+func Test_Prepend(t *testing.T) {
+	existing := []corev1.EnvVar{{Name: "STATSD_HOST", Value: "$(NODEIP)"}, {Name: "LOG_LEVEL", Value: "info"}}
+	cases := map[string]struct {
+		patchable PodPatchable
+		want      []string
+	}{
+		"VarsPatch": {VarsPatch(map[string]string{"NODEIP": "status.hostIP", "NODENAME": "spec.nodeName"}, Prepend()), []string{"NODEIP", "NODENAME", "STATSD_HOST", "LOG_LEVEL"}},
+		"EnvPatch":  {EnvPatch(corev1.EnvVar{Name: "REGION", Value: "eu-west-1"}, Prepend()), []string{"REGION", "STATSD_HOST", "LOG_LEVEL"}},
+		"replace":   {VarsPatch(map[string]string{"NODEIP": "status.hostIP", "LOG_LEVEL": "metadata.name"}, Prepend()), []string{"NODEIP", "STATSD_HOST", "LOG_LEVEL"}},
+	}
+
+	for n, tc := range cases {
+		tc := tc
+		t.Run(n, func(t *testing.T) {
+			pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Env: existing}}}}
+			ops, err := tc.patchable(pod)
+			if err != nil {
+				t.Fatalf("err=%v, want nil", err)
+			}
+			patched := applyPatch(t, pod, ops)
+			var actual []string
+			for _, env := range patched.Spec.Containers[0].Env {
+				actual = append(actual, env.Name)
+			}
+			if !cmp.Equal(actual, tc.want) {
+				t.Errorf("env mismatch (+want -got)\n%s", cmp.Diff(actual, tc.want))
+			}
+		})
+	}
+}
+
+func Test_Prepend_without_env(t *testing.T) {
+	pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}}}
+	ops, err := VarPatch("NODEIP", "status.hostIP", Prepend())(pod)
+	if err != nil {
+		t.Fatalf("err=%v, want nil", err)
+	}
+	patched := applyPatch(t, pod, ops)
+	if len(patched.Spec.Containers[0].Env) != 1 || patched.Spec.Containers[0].Env[0].Name != "NODEIP" {
+		t.Errorf("env=%v, want NODEIP", patched.Spec.Containers[0].Env)
+	}
+}
+
+func Test_ImageEnvPatch(t *testing.T) {
+	pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{
+		{Name: "proxy", Image: "envoyproxy/envoy:v1.16.0"},
+		{Name: "api", Image: "openjdk:11-jre", Env: []corev1.EnvVar{{Name: "JAVA_OPTS", Value: "-Xmx1g"}}},
+		{Name: "web", Image: "node:14-alpine"},
+		{Name: "worker", Image: "eclipse-temurin:11"},
+	}}}
+	rules := []ImageEnv{
+		{Pattern: regexp.MustCompile(`openjdk|temurin`), Env: map[string]string{"JAVA_OPTS": "-XX:MaxRAMPercentage=75"}},
+		{Pattern: regexp.MustCompile(`^node:`), Env: map[string]string{"NODE_OPTIONS": "--max-old-space-size=512", "NODE_ENV": "production"}},
+	}
+	ops, err := ImageEnvPatch(rules...)(pod)
+	if err != nil {
+		t.Fatalf("err=%v, want nil", err)
+	}
+
+	patched := applyPatch(t, pod, ops)
+	expected := [][]corev1.EnvVar{
+		nil,
+		{{Name: "JAVA_OPTS", Value: "-XX:MaxRAMPercentage=75"}},
+		{{Name: "NODE_ENV", Value: "production"}, {Name: "NODE_OPTIONS", Value: "--max-old-space-size=512"}},
+		{{Name: "JAVA_OPTS", Value: "-XX:MaxRAMPercentage=75"}},
+	}
+	for i, c := range patched.Spec.Containers {
+		if !cmp.Equal(c.Env, expected[i]) {
+			t.Errorf("containers[%d] %s env mismatch (+want -got)\n%s", i, c.Name, cmp.Diff(c.Env, expected[i]))
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package majortom
+
+import (
+	corev1 "k8s.io/api/core/v1"
+)
+
+// WhenOwnerKind delegates to p only when the pod is controlled by an owner of the given kind (e.g. Job, ReplicaSet).
+func WhenOwnerKind(kind string, p PodPatchable) PodPatchable {
+	return func(pod *corev1.Pod) ([]operation, error) {
+		for _, ref := range pod.ObjectMeta.OwnerReferences {
+			if ref.Controller != nil && *ref.Controller && ref.Kind == kind {
+				return p(pod)
+			}
+		}
+		return nil, nil
+	}
+}
+
+// WhenNoController delegates to p only when the pod has no controller owner, i.e. bare pods.
+func WhenNoController(p PodPatchable) PodPatchable {
+	return func(pod *corev1.Pod) ([]operation, error) {
+		for _, ref := range pod.ObjectMeta.OwnerReferences {
+			if ref.Controller != nil && *ref.Controller {
+				return nil, nil
+			}
+		}
+		return p(pod)
+	}
+}
+
+// WhenServiceAccount delegates to p only when the pod runs as one of the named service accounts. An unset service
+// account is treated as "default" which is what the ServiceAccount admission plugin assigns.
+func WhenServiceAccount(names []string, p PodPatchable) PodPatchable {
+	set := map[string]bool{}
+	for _, name := range names {
+		set[name] = true
+	}
+	return func(pod *corev1.Pod) ([]operation, error) {
+		sa := pod.Spec.ServiceAccountName
+		if sa == "" {
+			sa = "default"
+		}
+		if !set[sa] {
+			return nil, nil
+		}
+		return p(pod)
+	}
+}
+
+// Chain applies each patchable in order. Each patchable sees the pod as modified by those before it so, for example,
+// two patchables adding labels to a pod without labels don't both create the labels object.
+func Chain(ps ...PodPatchable) PodPatchable {
+	return func(pod *corev1.Pod) ([]operation, error) {
+		var all []operation
+		current := pod
+		for i, p := range ps {
+			ops, err := p(current)
+			if err != nil {
+				return nil, err
+			}
+			if len(ops) == 0 {
+				continue
+			}
+			all = append(all, ops...)
+			if i == len(ps)-1 {
+				break
+			}
+			current, err = ApplyOps(current, ops)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return all, nil
+	}
+}
+
+// WhenLabel delegates to p only when the pod has the label key with value.
+func WhenLabel(key, value string, p PodPatchable) PodPatchable {
+	return func(pod *corev1.Pod) ([]operation, error) {
+		v, ok := pod.ObjectMeta.Labels[key]
+		if !ok || v != value {
+			return nil, nil
+		}
+		return p(pod)
+	}
+}
+
+// WhenHasContainerPorts delegates to p only when at least one container declares a port. It keeps traffic oriented
+// injection (e.g. mesh sidecars) away from batch pods that don't serve.
+func WhenHasContainerPorts(p PodPatchable) PodPatchable {
+	return func(pod *corev1.Pod) ([]operation, error) {
+		for _, c := range pod.Spec.Containers {
+			if len(c.Ports) > 0 {
+				return p(pod)
+			}
+		}
+		return nil, nil
+	}
+}
+
+// WhenHasVolume delegates to p only when the pod declares a volume named nameOrDriver or a CSI volume using the
+// driver nameOrDriver.
+func WhenHasVolume(nameOrDriver string, p PodPatchable) PodPatchable {
+	return func(pod *corev1.Pod) ([]operation, error) {
+		for _, v := range pod.Spec.Volumes {
+			if v.Name == nameOrDriver || (v.CSI != nil && v.CSI.Driver == nameOrDriver) {
+				return p(pod)
+			}
+		}
+		return nil, nil
+	}
+}
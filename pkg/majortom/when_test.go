@@ -0,0 +1,160 @@
+package majortom
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func ownedPod(kind string) *corev1.Pod {
+	controller := true
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			OwnerReferences: []metav1.OwnerReference{
+				{Kind: kind, Name: "owner-abc123", Controller: &controller},
+			},
+		},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Image: "nginx:latest"}}},
+	}
+}
+
+func Test_WhenOwnerKind(t *testing.T) {
+	cases := map[string]struct {
+		pod *corev1.Pod
+		ops int
+	}{
+		"job owned":        {ownedPod("Job"), 1},
+		"replicaset owned": {ownedPod("ReplicaSet"), 0},
+		"ownerless":        {&corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Image: "nginx:latest"}}}}, 0},
+	}
+
+	for n, tc := range cases {
+		tc := tc
+		t.Run(n, func(t *testing.T) {
+			ops, err := WhenOwnerKind("Job", VarPatch("NODEIP", "status.hostIP"))(tc.pod)
+			if err != nil {
+				t.Errorf("err=%v, want nil", err)
+			}
+			if len(ops) != tc.ops {
+				t.Errorf("len(ops)=%v, want %v", len(ops), tc.ops)
+			}
+		})
+	}
+}
+
+func Test_WhenOwnerKind_ignores_non_controller_owner(t *testing.T) {
+	pod := ownedPod("Job")
+	pod.ObjectMeta.OwnerReferences[0].Controller = nil
+	ops, err := WhenOwnerKind("Job", VarPatch("NODEIP", "status.hostIP"))(pod)
+	if err != nil {
+		t.Errorf("err=%v, want nil", err)
+	}
+	if len(ops) != 0 {
+		t.Errorf("len(ops)=%v, want 0", len(ops))
+	}
+}
+
+func Test_WhenNoController(t *testing.T) {
+	nonController := ownedPod("ReplicaSet")
+	nonController.ObjectMeta.OwnerReferences[0].Controller = nil
+	cases := map[string]struct {
+		pod *corev1.Pod
+		ops int
+	}{
+		"controller owned":     {ownedPod("ReplicaSet"), 0},
+		"non-controller owner": {nonController, 1},
+		"bare":                 {&corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Image: "nginx:latest"}}}}, 1},
+	}
+
+	for n, tc := range cases {
+		tc := tc
+		t.Run(n, func(t *testing.T) {
+			ops, err := WhenNoController(VarPatch("NODEIP", "status.hostIP"))(tc.pod)
+			if err != nil {
+				t.Errorf("err=%v, want nil", err)
+			}
+			if len(ops) != tc.ops {
+				t.Errorf("len(ops)=%v, want %v", len(ops), tc.ops)
+			}
+		})
+	}
+}
+
+func Test_WhenServiceAccount(t *testing.T) {
+	cases := map[string]struct {
+		names []string
+		sa    string
+		ops   int
+	}{
+		"matching":              {[]string{"deploy-bot"}, "deploy-bot", 1},
+		"non-matching":          {[]string{"deploy-bot"}, "web", 0},
+		"default unset":         {[]string{"deploy-bot"}, "", 0},
+		"default matching":      {[]string{"default"}, "", 1},
+		"default matching name": {[]string{"default"}, "default", 1},
+	}
+
+	for n, tc := range cases {
+		tc := tc
+		t.Run(n, func(t *testing.T) {
+			pod := &corev1.Pod{Spec: corev1.PodSpec{ServiceAccountName: tc.sa, Containers: []corev1.Container{{Image: "nginx:latest"}}}}
+			ops, err := WhenServiceAccount(tc.names, VarPatch("NODEIP", "status.hostIP"))(pod)
+			if err != nil {
+				t.Errorf("err=%v, want nil", err)
+			}
+			if len(ops) != tc.ops {
+				t.Errorf("len(ops)=%v, want %v", len(ops), tc.ops)
+			}
+		})
+	}
+}
+
+func Test_WhenHasContainerPorts(t *testing.T) {
+	cases := map[string]struct {
+		ports []corev1.ContainerPort
+		ops   int
+	}{
+		"with ports":    {[]corev1.ContainerPort{{ContainerPort: 8080}}, 1},
+		"without ports": {nil, 0},
+	}
+
+	for n, tc := range cases {
+		tc := tc
+		t.Run(n, func(t *testing.T) {
+			pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Image: "nginx:latest", Ports: tc.ports}}}}
+			ops, err := WhenHasContainerPorts(VarPatch("NODEIP", "status.hostIP"))(pod)
+			if err != nil {
+				t.Errorf("err=%v, want nil", err)
+			}
+			if len(ops) != tc.ops {
+				t.Errorf("len(ops)=%v, want %v", len(ops), tc.ops)
+			}
+		})
+	}
+}
+
+func Test_WhenHasVolume(t *testing.T) {
+	cases := map[string]struct {
+		volumes []corev1.Volume
+		ops     int
+	}{
+		"matching name":       {[]corev1.Volume{{Name: "secrets-store"}}, 1},
+		"matching csi driver": {[]corev1.Volume{{Name: "creds", VolumeSource: corev1.VolumeSource{CSI: &corev1.CSIVolumeSource{Driver: "secrets-store"}}}}, 1},
+		"other csi driver":    {[]corev1.Volume{{Name: "data", VolumeSource: corev1.VolumeSource{CSI: &corev1.CSIVolumeSource{Driver: "ebs.csi.aws.com"}}}}, 0},
+		"no volumes":          {nil, 0},
+	}
+
+	for n, tc := range cases {
+		tc := tc
+		t.Run(n, func(t *testing.T) {
+			pod := &corev1.Pod{Spec: corev1.PodSpec{Volumes: tc.volumes, Containers: []corev1.Container{{Image: "nginx:latest"}}}}
+			ops, err := WhenHasVolume("secrets-store", VarPatch("NODEIP", "status.hostIP"))(pod)
+			if err != nil {
+				t.Errorf("err=%v, want nil", err)
+			}
+			if len(ops) != tc.ops {
+				t.Errorf("len(ops)=%v, want %v", len(ops), tc.ops)
+			}
+		})
+	}
+}